@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageResizer/pkg/resizer"
+	"github.com/demius1992/Image-service/imageUploader/pkg/config"
+	"github.com/demius1992/Image-service/imageUploader/pkg/server"
+	"github.com/demius1992/Image-service/storage"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+)
+
+// The topics only exist in memory, so they do not need to be configured
+const (
+	requestsTopic   = "resize-requests"
+	eventsTopic     = "resize-events"
+	deadLetterTopic = "resize-dead-letters"
+)
+
+func main() {
+	// Load the environments variables, the .env file being optional
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		logrus.Fatalf("error loading env variables %s", err.Error())
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("%s", err.Error())
+	}
+	cfg.KafkaInputTopic = eventsTopic
+	cfg.KafkaOutputTopic = requestsTopic
+
+	// Store the images on the local disk unless another driver is selected
+	if cfg.StorageDriver == "" {
+		cfg.StorageDriver = "fs"
+	}
+	if cfg.StoragePath == "" {
+		cfg.StoragePath = "data"
+	}
+	objectStore, err := storage.New(cfg.Storage())
+	if err != nil {
+		logrus.Fatalf("%s", err.Error())
+	}
+
+	// Carry the messages between the uploader and the resizer in memory
+	messages := bus.NewMemoryBus()
+	defer messages.Close()
+
+	imageResizer, err := resizer.New(objectStore, messages, resizer.Topics{
+		Input:      requestsTopic,
+		Output:     eventsTopic,
+		DeadLetter: deadLetterTopic,
+	})
+	if err != nil {
+		logrus.Fatalf("%s", err.Error())
+	}
+
+	app, err := server.NewAppWithBackends(cfg, objectStore, messages)
+	if err != nil {
+		logrus.Fatalf("%s", err.Error())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// A failed resizer stops the uploader too
+	resized := make(chan error, 1)
+	go func() {
+		err := imageResizer.Run(ctx)
+		stop()
+		resized <- err
+	}()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	if err := app.Serve(ctx, port); err != nil {
+		logrus.Errorf("error occured while shutting down the server: %v", err)
+	}
+
+	if err := <-resized; err != nil && !errors.Is(err, context.Canceled) {
+		logrus.Errorf("error occured while processing images: %v", err)
+	}
+}
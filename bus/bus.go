@@ -0,0 +1,80 @@
+// Package bus carries the messages between the uploader and the resizer. The messages go through
+// Kafka, or through in-process channels when both services run in the same process.
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrClosed is returned by the subscriptions and buses used after they were closed.
+var ErrClosed = errors.New("message bus closed")
+
+// Message is a keyed message with headers.
+type Message struct {
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+
+	// Topic, Partition, Offset and Time locate the received messages. The offsets increase
+	// within a partition.
+	Topic     string
+	Partition int
+	Offset    int64
+	Time      time.Time
+}
+
+// Header returns the value of the header with the given key.
+func (m *Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// Bus provides an interface for publishing and subscribing to the topics.
+type Bus interface {
+	// Publish sends the messages to the topic. Messages with the same key are received in the
+	// order they were published.
+	Publish(ctx context.Context, topic string, msgs ...*Message) error
+	// Subscribe receives the messages of the topic as a member of the group: every message is
+	// received by a single member of every group.
+	Subscribe(topic, group string) (Subscription, error)
+	// CreateTopics creates the topics that do not exist.
+	CreateTopics(topics ...string) error
+	Close() error
+}
+
+// Subscription receives the messages of a topic for a group. A received message is redelivered,
+// to this or to another member of the group, until it is acknowledged.
+type Subscription interface {
+	// Receive blocks until the next message is available or the context is done.
+	Receive(ctx context.Context) (*Message, error)
+	// Ack acknowledges the processed messages. Like a Kafka commit, it also acknowledges the
+	// earlier messages of their partitions, so the messages processed concurrently must be
+	// acknowledged in order.
+	Ack(ctx context.Context, msgs ...*Message) error
+	// Nack gives a message back for redelivery. Kafka only redelivers it once the group
+	// rebalances or the subscription is opened again, unless a later message was acknowledged.
+	Nack(ctx context.Context, msg *Message) error
+	// Close leaves the group; the messages received but not acknowledged are redelivered.
+	Close() error
+}
+
+// Config selects and configures the message bus.
+type Config struct {
+	// Driver is kafka or memory. It defaults to kafka.
+	Driver  string
+	Brokers []string
+}
+
+// New creates the message bus selected in the configuration.
+func New(cfg Config) (Bus, error) {
+	switch cfg.Driver {
+	case "", "kafka":
+		return NewKafkaBus(cfg.Brokers), nil
+	case "memory":
+		return NewMemoryBus(), nil
+	default:
+		return nil, fmt.Errorf("unsupported message bus %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/demius1992/Image-service/bus
+
+go 1.20
+
+require github.com/segmentio/kafka-go v0.4.38
+
+require (
+	github.com/klauspost/compress v1.15.14 // indirect
+	github.com/pierrec/lz4/v4 v4.1.17 // indirect
+)
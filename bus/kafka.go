@@ -0,0 +1,142 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"net"
+	"strconv"
+)
+
+// KafkaBus carries the messages through Kafka. The messages are spread over the partitions by
+// key, so the messages of a key stay ordered.
+type KafkaBus struct {
+	brokers []string
+	writer  *kafka.Writer
+}
+
+// NewKafkaBus creates a new KafkaBus instance connecting to the brokers.
+func NewKafkaBus(brokers []string) *KafkaBus {
+	return &KafkaBus{
+		brokers: brokers,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+		},
+	}
+}
+
+// Publish writes the messages to the topic.
+func (b *KafkaBus) Publish(ctx context.Context, topic string, msgs ...*Message) error {
+	messages := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		messages[i] = kafka.Message{
+			Topic: topic,
+			Key:   msg.Key,
+			Value: msg.Value,
+		}
+		for key, value := range msg.Headers {
+			messages[i].Headers = append(messages[i].Headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+	}
+	return b.writer.WriteMessages(ctx, messages...)
+}
+
+// Subscribe reads the topic as a member of the consumer group, from the first offset for a new
+// group.
+func (b *KafkaBus) Subscribe(topic, group string) (Subscription, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     b.brokers,
+		Topic:       topic,
+		GroupID:     group,
+		StartOffset: kafka.FirstOffset,
+		MinBytes:    1,
+		MaxBytes:    10e6, // 10MB
+	})
+	return &kafkaSubscription{reader: reader}, nil
+}
+
+// CreateTopics creates the topics with 3 partitions through the controller of the cluster, for
+// the clusters where auto.create.topics.enable is false.
+func (b *KafkaBus) CreateTopics(topics ...string) error {
+	if len(b.brokers) == 0 {
+		return fmt.Errorf("no Kafka broker configured")
+	}
+
+	conn, err := kafka.Dial("tcp", b.brokers[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	configs := make([]kafka.TopicConfig, len(topics))
+	for i, topic := range topics {
+		configs[i] = kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     3,
+			ReplicationFactor: 1,
+		}
+	}
+	return controllerConn.CreateTopics(configs...)
+}
+
+// Close closes the writer. The subscriptions are closed by their owners.
+func (b *KafkaBus) Close() error {
+	return b.writer.Close()
+}
+
+type kafkaSubscription struct {
+	reader *kafka.Reader
+}
+
+// Receive fetches the next message without committing its offset.
+func (s *kafkaSubscription) Receive(ctx context.Context) (*Message, error) {
+	msg, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	received := &Message{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   make(map[string]string, len(msg.Headers)),
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Time:      msg.Time,
+	}
+	for _, h := range msg.Headers {
+		received.Headers[h.Key] = string(h.Value)
+	}
+	return received, nil
+}
+
+// Ack commits the offsets of the messages for the consumer group.
+func (s *kafkaSubscription) Ack(ctx context.Context, msgs ...*Message) error {
+	messages := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		messages[i] = kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	}
+	return s.reader.CommitMessages(ctx, messages...)
+}
+
+// Nack leaves the offset of the message uncommitted.
+func (s *kafkaSubscription) Nack(context.Context, *Message) error {
+	return nil
+}
+
+// Close leaves the consumer group.
+func (s *kafkaSubscription) Close() error {
+	return s.reader.Close()
+}
@@ -0,0 +1,285 @@
+package bus
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryBus carries the messages through memory, for the services running in the same process.
+// Every topic is a single partition log, kept until all its groups acknowledged the messages; the
+// messages published before a group subscribes are delivered to it, like Kafka does from the first
+// offset, unless the topic had no group keeping them: the messages of a topic without groups are
+// dropped. The messages are lost on restart.
+type MemoryBus struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	closed bool
+}
+
+// compactThreshold is the number of messages dropped from the front of a log after which the log
+// is copied, releasing the array the dropped messages were held in.
+const compactThreshold = 1024
+
+type memoryTopic struct {
+	// base is the offset of the first message of the log
+	base int64
+	log  []*Message
+	// dropped counts the messages dropped since the log was last copied
+	dropped int64
+	// changed is closed and replaced whenever a message becomes available
+	changed chan struct{}
+	groups  map[string]*memoryGroup
+}
+
+type memoryGroup struct {
+	// next is the offset of the next message never delivered to the group
+	next int64
+	// redeliver holds the offsets given back, delivered again before the new messages
+	redeliver []int64
+	// inflight holds the offsets delivered and not acknowledged, with their subscription
+	inflight map[int64]*memorySubscription
+}
+
+// NewMemoryBus creates a new MemoryBus instance.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		topics: make(map[string]*memoryTopic),
+	}
+}
+
+// topic returns the topic, creating it if needed. The lock must be held.
+func (b *MemoryBus) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{
+			changed: make(chan struct{}),
+			groups:  make(map[string]*memoryGroup),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// notify wakes up the subscriptions waiting for a message. The lock must be held.
+func (t *memoryTopic) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Publish appends the messages to the topic log.
+func (b *MemoryBus) Publish(_ context.Context, topic string, msgs ...*Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	t := b.topic(topic)
+	now := time.Now().UTC()
+	for _, msg := range msgs {
+		published := &Message{
+			Key:     append([]byte(nil), msg.Key...),
+			Value:   append([]byte(nil), msg.Value...),
+			Headers: make(map[string]string, len(msg.Headers)),
+			Topic:   topic,
+			Offset:  t.base + int64(len(t.log)),
+			Time:    now,
+		}
+		for key, value := range msg.Headers {
+			published.Headers[key] = value
+		}
+		t.log = append(t.log, published)
+	}
+	t.trim()
+	t.notify()
+	return nil
+}
+
+// Subscribe joins the group of the topic.
+func (b *MemoryBus) Subscribe(topic, group string) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	t := b.topic(topic)
+	if _, ok := t.groups[group]; !ok {
+		t.groups[group] = &memoryGroup{next: t.base, inflight: make(map[int64]*memorySubscription)}
+	}
+	return &memorySubscription{bus: b, topic: topic, group: group}, nil
+}
+
+// CreateTopics creates the topics.
+func (b *MemoryBus) CreateTopics(topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		b.topic(topic)
+	}
+	return nil
+}
+
+// Close stops the subscriptions.
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		for _, t := range b.topics {
+			t.notify()
+		}
+	}
+	return nil
+}
+
+// trim drops the messages of the log acknowledged by all the groups, or all the messages when the
+// topic has no groups. The lock must be held.
+func (t *memoryTopic) trim() {
+	low := t.base + int64(len(t.log))
+	for _, g := range t.groups {
+		if g.next < low {
+			low = g.next
+		}
+		for _, offset := range g.redeliver {
+			if offset < low {
+				low = offset
+			}
+		}
+		for offset := range g.inflight {
+			if offset < low {
+				low = offset
+			}
+		}
+	}
+
+	drop := low - t.base
+	if drop <= 0 {
+		return
+	}
+
+	// Re-slicing keeps the acks cheap; the array is only copied once enough messages were dropped
+	for i := int64(0); i < drop; i++ {
+		t.log[i] = nil
+	}
+	t.log = t.log[drop:]
+	t.base = low
+	t.dropped += drop
+	if t.dropped >= compactThreshold {
+		t.log = append([]*Message(nil), t.log...)
+		t.dropped = 0
+	}
+}
+
+// giveBack schedules the redelivery of the offset, keeping the redeliveries in the log order.
+func (g *memoryGroup) giveBack(offset int64) {
+	g.redeliver = append(g.redeliver, offset)
+	sort.Slice(g.redeliver, func(i, j int) bool { return g.redeliver[i] < g.redeliver[j] })
+}
+
+type memorySubscription struct {
+	bus    *MemoryBus
+	topic  string
+	group  string
+	closed bool
+}
+
+// Receive returns the next message given back or never delivered to the group.
+func (s *memorySubscription) Receive(ctx context.Context) (*Message, error) {
+	for {
+		s.bus.mu.Lock()
+		if s.bus.closed || s.closed {
+			s.bus.mu.Unlock()
+			return nil, ErrClosed
+		}
+
+		t := s.bus.topics[s.topic]
+		g := t.groups[s.group]
+		offset := int64(-1)
+		if len(g.redeliver) > 0 {
+			offset, g.redeliver = g.redeliver[0], g.redeliver[1:]
+		} else if g.next < t.base+int64(len(t.log)) {
+			offset = g.next
+			g.next++
+		}
+		if offset >= 0 {
+			g.inflight[offset] = s
+			msg := *t.log[offset-t.base]
+			s.bus.mu.Unlock()
+			return &msg, nil
+		}
+
+		changed := t.changed
+		s.bus.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Ack acknowledges the messages and the earlier messages of the log, like a Kafka commit.
+func (s *memorySubscription) Ack(_ context.Context, msgs ...*Message) error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	t := s.bus.topics[s.topic]
+	g := t.groups[s.group]
+	for _, msg := range msgs {
+		for offset := range g.inflight {
+			if offset <= msg.Offset {
+				delete(g.inflight, offset)
+			}
+		}
+		for len(g.redeliver) > 0 && g.redeliver[0] <= msg.Offset {
+			g.redeliver = g.redeliver[1:]
+		}
+	}
+	t.trim()
+	return nil
+}
+
+// Nack gives the message back to the group, ahead of the new messages.
+func (s *memorySubscription) Nack(_ context.Context, msg *Message) error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	t := s.bus.topics[s.topic]
+	g := t.groups[s.group]
+	if _, ok := g.inflight[msg.Offset]; ok {
+		delete(g.inflight, msg.Offset)
+		g.giveBack(msg.Offset)
+		t.notify()
+	}
+	return nil
+}
+
+// Close gives the messages received and not acknowledged back to the group.
+func (s *memorySubscription) Close() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	t := s.bus.topics[s.topic]
+	g := t.groups[s.group]
+	for offset, owner := range g.inflight {
+		if owner == s {
+			delete(g.inflight, offset)
+			g.giveBack(offset)
+		}
+	}
+	t.notify()
+	return nil
+}
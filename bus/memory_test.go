@@ -0,0 +1,292 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// receiveTimeout bounds the receives expected to find no message.
+const receiveTimeout = 20 * time.Millisecond
+
+func TestMemoryBusDelivery(t *testing.T) {
+	// An op publishes the next message, or acts on a subscription. A receive expects the message
+	// at the offset, or no message when the offset is -1.
+	type op struct {
+		do     string
+		sub    string
+		offset int64
+	}
+	publish := op{do: "publish"}
+	receive := func(sub string, offset int64) op { return op{do: "receive", sub: sub, offset: offset} }
+	ack := func(sub string, offset int64) op { return op{do: "ack", sub: sub, offset: offset} }
+	nack := func(sub string, offset int64) op { return op{do: "nack", sub: sub, offset: offset} }
+	closeSub := func(sub string) op { return op{do: "close", sub: sub} }
+
+	tests := []struct {
+		name string
+		// groups maps the subscriptions to their group
+		groups map[string]string
+		ops    []op
+	}{
+		{"in order", map[string]string{"a": "g"}, []op{
+			publish, publish, publish,
+			receive("a", 0), receive("a", 1), receive("a", 2), receive("a", -1),
+		}},
+		{"published after subscribing", map[string]string{"a": "g"}, []op{
+			receive("a", -1), publish, receive("a", 0),
+		}},
+		{"nack redelivers before new messages", map[string]string{"a": "g"}, []op{
+			publish, publish, publish,
+			receive("a", 0), receive("a", 1), nack("a", 0), receive("a", 0), receive("a", 2),
+		}},
+		{"nacked messages are redelivered in order", map[string]string{"a": "g"}, []op{
+			publish, publish, publish,
+			receive("a", 0), receive("a", 1), nack("a", 1), nack("a", 0),
+			receive("a", 0), receive("a", 1), receive("a", 2),
+		}},
+		{"nack of an acknowledged message", map[string]string{"a": "g"}, []op{
+			publish, receive("a", 0), ack("a", 0), nack("a", 0), receive("a", -1),
+		}},
+		{"ack is cumulative", map[string]string{"a": "g", "b": "g"}, []op{
+			publish, publish, publish,
+			receive("a", 0), receive("a", 1), receive("a", 2), ack("a", 1), closeSub("a"),
+			receive("b", 2), receive("b", -1),
+		}},
+		{"ack drops the pending redeliveries", map[string]string{"a": "g"}, []op{
+			publish, publish, publish,
+			receive("a", 0), receive("a", 1), receive("a", 2), nack("a", 0), ack("a", 2),
+			receive("a", -1),
+		}},
+		{"closing redelivers to the group", map[string]string{"a": "g", "b": "g"}, []op{
+			publish, publish,
+			receive("a", 0), receive("b", 1), closeSub("a"), receive("b", 0), receive("b", -1),
+		}},
+		{"members share the messages", map[string]string{"a": "g", "b": "g"}, []op{
+			publish, publish,
+			receive("a", 0), receive("b", 1), receive("a", -1), receive("b", -1),
+		}},
+		{"groups receive every message", map[string]string{"a": "g1", "b": "g2"}, []op{
+			publish, publish,
+			receive("a", 0), receive("a", 1), receive("b", 0), ack("a", 1), receive("b", 1),
+		}},
+		{"messages kept for a slower group", map[string]string{"a": "g1", "b": "g2"}, []op{
+			publish, receive("a", 0), ack("a", 0), publish, receive("b", 0), receive("b", 1),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBus()
+			defer b.Close()
+
+			subs := make(map[string]Subscription)
+			for name, group := range tt.groups {
+				sub, err := b.Subscribe("topic", group)
+				if err != nil {
+					t.Fatalf("Subscribe: %v", err)
+				}
+				subs[name] = sub
+			}
+
+			received := make(map[int64]*Message)
+			published := 0
+			for i, op := range tt.ops {
+				sub := subs[op.sub]
+				var err error
+				switch op.do {
+				case "publish":
+					err = b.Publish(context.Background(), "topic", &Message{
+						Key:     []byte(strconv.Itoa(published)),
+						Headers: map[string]string{"n": strconv.Itoa(published)},
+					})
+					published++
+				case "receive":
+					ctx, cancel := context.WithTimeout(context.Background(), receiveTimeout)
+					var msg *Message
+					msg, err = sub.Receive(ctx)
+					cancel()
+					switch {
+					case op.offset < 0 && err == nil:
+						t.Fatalf("op %d: %s received offset %d, want nothing", i, op.sub, msg.Offset)
+					case op.offset < 0:
+						if !errors.Is(err, context.DeadlineExceeded) {
+							t.Fatalf("op %d: Receive() = %v, want %v", i, err, context.DeadlineExceeded)
+						}
+						err = nil
+					case err == nil && msg.Offset != op.offset:
+						t.Fatalf("op %d: %s received offset %d, want %d", i, op.sub, msg.Offset, op.offset)
+					case err == nil:
+						if n := strconv.Itoa(int(op.offset)); string(msg.Key) != n || msg.Header("n") != n {
+							t.Fatalf("op %d: offset %d holds message %q", i, op.offset, msg.Key)
+						}
+						received[msg.Offset] = msg
+					}
+				case "ack":
+					err = sub.Ack(context.Background(), received[op.offset])
+				case "nack":
+					err = sub.Nack(context.Background(), received[op.offset])
+				case "close":
+					err = sub.Close()
+				}
+				if err != nil {
+					t.Fatalf("op %d: %s %s: %v", i, op.do, op.sub, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryBusPublishCopies(t *testing.T) {
+	b := NewMemoryBus()
+	sub, _ := b.Subscribe("topic", "g")
+
+	msg := &Message{Key: []byte("key"), Value: []byte("value"), Headers: map[string]string{"h": "v"}}
+	if err := b.Publish(context.Background(), "topic", msg); err != nil {
+		t.Fatal(err)
+	}
+	msg.Key[0], msg.Value[0], msg.Headers["h"] = 'x', 'x', "x"
+
+	got, err := sub.Receive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Key) != "key" || string(got.Value) != "value" || got.Header("h") != "v" || got.Topic != "topic" {
+		t.Errorf("received %q=%q %v on %q, want the message as published", got.Key, got.Value, got.Headers, got.Topic)
+	}
+}
+
+func TestMemoryBusReceiveWaits(t *testing.T) {
+	b := NewMemoryBus()
+	sub, _ := b.Subscribe("topic", "g")
+
+	received := make(chan *Message)
+	go func() {
+		msg, err := sub.Receive(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		received <- msg
+	}()
+
+	time.Sleep(receiveTimeout)
+	if err := b.Publish(context.Background(), "topic", &Message{Key: []byte("k")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if string(msg.Key) != "k" {
+			t.Errorf("received %q, want k", msg.Key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiting receive was not woken up by the publish")
+	}
+}
+
+func TestMemoryBusClose(t *testing.T) {
+	b := NewMemoryBus()
+	sub, _ := b.Subscribe("topic", "g")
+
+	woken := make(chan error)
+	go func() {
+		_, err := sub.Receive(context.Background())
+		woken <- err
+	}()
+
+	time.Sleep(receiveTimeout)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-woken:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Receive() = %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiting receive was not woken up by the close")
+	}
+
+	if err := b.Publish(context.Background(), "topic", &Message{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish() = %v, want %v", err, ErrClosed)
+	}
+	if _, err := b.Subscribe("topic", "g"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe() = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestMemoryBusClosedSubscription(t *testing.T) {
+	b := NewMemoryBus()
+	defer b.Close()
+	sub, _ := b.Subscribe("topic", "g")
+
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Close(); err != nil {
+		t.Errorf("second Close() = %v, want nil", err)
+	}
+	if _, err := sub.Receive(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Receive() = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestMemoryBusTrim(t *testing.T) {
+	tests := []struct {
+		name      string
+		subscribe bool
+		messages  int
+	}{
+		{"topic without groups", false, 10},
+		{"acknowledged messages", true, 10},
+		{"past the compaction threshold", true, 3*compactThreshold + 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBus()
+			defer b.Close()
+
+			var sub Subscription
+			if tt.subscribe {
+				sub, _ = b.Subscribe("topic", "g")
+			}
+			for i := 0; i < tt.messages; i++ {
+				if err := b.Publish(context.Background(), "topic", &Message{Key: []byte(strconv.Itoa(i))}); err != nil {
+					t.Fatal(err)
+				}
+				if sub == nil {
+					continue
+				}
+
+				msg, err := sub.Receive(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg.Offset != int64(i) || string(msg.Key) != strconv.Itoa(i) {
+					t.Fatalf("received offset %d holding %q, want offset %d", msg.Offset, msg.Key, i)
+				}
+				if err = sub.Ack(context.Background(), msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			b.mu.Lock()
+			topic := b.topics["topic"]
+			retained, base := len(topic.log), topic.base
+			b.mu.Unlock()
+			if retained != 0 || base != int64(tt.messages) {
+				t.Errorf("retained %d messages from offset %d, want none from offset %d", retained, base, tt.messages)
+			}
+
+			// A group joining later starts after the dropped messages
+			late, _ := b.Subscribe("topic", "late")
+			ctx, cancel := context.WithTimeout(context.Background(), receiveTimeout)
+			defer cancel()
+			if msg, err := late.Receive(ctx); err == nil {
+				t.Errorf("a new group received the dropped offset %d", msg.Offset)
+			}
+		})
+	}
+}
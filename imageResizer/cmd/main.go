@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
-	"github.com/demius1992/Image-service/imageResizer/internal/repositories"
-	"github.com/demius1992/Image-service/imageResizer/internal/services"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageResizer/pkg/config"
+	"github.com/demius1992/Image-service/imageResizer/pkg/resizer"
+	"github.com/demius1992/Image-service/storage"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"os"
-	"strings"
 )
 
 func main() {
@@ -16,21 +17,33 @@ func main() {
 		logrus.Fatalf("error loading env variables %s", err.Error())
 	}
 
-	// Create new s3 repository
-	s3Repo, err := repositories.NewS3Repository(os.Getenv("S3_BUCKET"), os.Getenv("S3_REGION"))
+	// Create new storage repository
+	objectStore, err := storage.New(config.LoadStorage())
 	if err != nil {
 		logrus.Fatalln(err)
 	}
 
-	// Create a new Kafka service
-	kafkaService := services.NewKafkaService(strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
-		os.Getenv("KAFKA_INPUT_TOPIC"), os.Getenv("KAFKA_OUTPUT_TOPIC"), s3Repo)
-
-	// Create a new Image service
-	imageService := services.NewImageService(kafkaService, s3Repo)
+	// Create the resizer on the message bus
+	messages, err := bus.New(config.LoadBus())
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+	imageResizer, err := resizer.New(objectStore, messages, resizer.Topics{
+		Input:      os.Getenv("KAFKA_INPUT_TOPIC"),
+		Output:     os.Getenv("KAFKA_OUTPUT_TOPIC"),
+		DeadLetter: os.Getenv("KAFKA_DEAD_LETTER_TOPIC"),
+		GroupID:    os.Getenv("KAFKA_GROUP_ID"),
+	})
+	if err != nil {
+		logrus.Fatalln(err)
+	}
 
 	// Starting image processing
-	if err = imageService.ImageProcessor(context.Background()); err != nil {
+	err = imageResizer.Run(context.Background())
+	if closeErr := messages.Close(); closeErr != nil {
+		logrus.Errorln(closeErr)
+	}
+	if err != nil {
 		logrus.Fatalln(err)
 	}
 }
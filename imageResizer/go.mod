@@ -3,21 +3,30 @@ module github.com/demius1992/Image-service/imageResizer
 go 1.20
 
 require (
-	github.com/aws/aws-sdk-go v1.44.204
+	github.com/demius1992/Image-service/bus v0.0.0
+	github.com/demius1992/Image-service/storage v0.0.0
 	github.com/google/uuid v1.1.2
 	github.com/joho/godotenv v1.5.1
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
-	github.com/segmentio/kafka-go v0.4.38
 	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/image v0.18.0
 )
 
 require (
+	github.com/aws/aws-sdk-go v1.44.204 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/klauspost/compress v1.15.14 // indirect
 	github.com/pierrec/lz4/v4 v4.1.17 // indirect
+	github.com/segmentio/kafka-go v0.4.38 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
 	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+replace (
+	github.com/demius1992/Image-service/bus => ../bus
+	github.com/demius1992/Image-service/storage => ../storage
+)
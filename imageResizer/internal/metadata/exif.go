@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"encoding/binary"
+)
+
+const (
+	tagOrientation = 0x0112
+	tagGPSIFD      = 0x8825
+)
+
+// tiffTypeSizes holds the byte size of the TIFF field types.
+var tiffTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// ifdEntry is a single entry of a TIFF image file directory.
+type ifdEntry struct {
+	offset    int // offset of the 12 byte entry
+	tag       uint16
+	fieldType uint16
+	count     uint32
+}
+
+// tiffReader reads TIFF structures in the byte order given by their header.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(data []byte) (*tiffReader, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, false
+	}
+
+	return &tiffReader{data: data, order: order}, true
+}
+
+func isTIFF(data []byte) bool {
+	_, ok := newTIFFReader(data)
+	return ok
+}
+
+// entries returns the entries of the directory at offset.
+func (t *tiffReader) entries(offset int) []ifdEntry {
+	if offset < 8 || offset+2 > len(t.data) {
+		return nil
+	}
+
+	n := int(t.order.Uint16(t.data[offset:]))
+	if offset+2+n*12 > len(t.data) {
+		return nil
+	}
+
+	entries := make([]ifdEntry, n)
+	for i := range entries {
+		pos := offset + 2 + i*12
+		entries[i] = ifdEntry{
+			offset:    pos,
+			tag:       t.order.Uint16(t.data[pos:]),
+			fieldType: t.order.Uint16(t.data[pos+2:]),
+			count:     t.order.Uint32(t.data[pos+4:]),
+		}
+	}
+	return entries
+}
+
+// ifd0 returns the entries of the first directory.
+func (t *tiffReader) ifd0() []ifdEntry {
+	return t.entries(int(t.order.Uint32(t.data[4:])))
+}
+
+// orientation returns the orientation stored in the first directory or 1 if there is none.
+func (t *tiffReader) orientation() int {
+	for _, e := range t.ifd0() {
+		if e.tag == tagOrientation && e.fieldType == 3 && e.count == 1 {
+			if o := int(t.order.Uint16(t.data[e.offset+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// Orientation returns the EXIF orientation (1-8) of a JPEG, PNG or TIFF image, or 1 if it has none.
+func Orientation(data []byte) int {
+	var tiff []byte
+	switch {
+	case isJPEG(data):
+		tiff = jpegEXIF(data)
+	case isPNG(data):
+		tiff = pngEXIF(data)
+	case isTIFF(data):
+		tiff = data
+	}
+
+	t, ok := newTIFFReader(tiff)
+	if !ok {
+		return 1
+	}
+	return t.orientation()
+}
+
+// sanitizeEXIF modifies the TIFF structure of an EXIF block in place. It wipes the
+// GPS directory unless it is whitelisted and optionally resets the orientation.
+func sanitizeEXIF(tiff []byte, keep Whitelist, resetOrientation bool) {
+	t, ok := newTIFFReader(tiff)
+	if !ok {
+		return
+	}
+
+	for _, e := range t.ifd0() {
+		switch {
+		case e.tag == tagOrientation && resetOrientation && e.fieldType == 3 && e.count == 1:
+			t.order.PutUint16(tiff[e.offset+8:], 1)
+		case e.tag == tagGPSIFD && !keep[KindGPS]:
+			t.wipeDirectory(int(t.order.Uint32(tiff[e.offset+8:])))
+		}
+	}
+}
+
+// wipeDirectory zeroes the values of all entries of the directory at offset and empties it.
+func (t *tiffReader) wipeDirectory(offset int) {
+	entries := t.entries(offset)
+	for _, e := range entries {
+		size := tiffTypeSizes[e.fieldType] * int(e.count)
+		if size > 4 {
+			valueOffset := int(t.order.Uint32(t.data[e.offset+8:]))
+			if valueOffset >= 0 && valueOffset+size <= len(t.data) {
+				zero(t.data[valueOffset : valueOffset+size])
+			}
+		}
+		zero(t.data[e.offset : e.offset+12])
+	}
+	if len(entries) > 0 {
+		t.order.PutUint16(t.data[offset:], 0)
+	}
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,126 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+	markerAPP14 = 0xee
+	markerAPP15 = 0xef
+	markerCOM   = 0xfe
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtHdr  = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+var errInvalidJPEG = errors.New("metadata: invalid JPEG")
+
+func isJPEG(data []byte) bool {
+	return len(data) >= 4 && data[0] == 0xff && data[1] == markerSOI
+}
+
+// walkJPEG calls fn with every marker segment in front of the scan data, including
+// the marker and length bytes. It returns the offset where the scan data starts.
+func walkJPEG(data []byte, fn func(marker byte, segment []byte)) (int, error) {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return 0, errInvalidJPEG
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// Fill byte
+			pos++
+			continue
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return pos, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return 0, errInvalidJPEG
+		}
+
+		fn(marker, data[pos:end])
+		pos = end
+	}
+
+	return 0, errInvalidJPEG
+}
+
+// jpegSegmentKind classifies a marker segment. It returns false for the segments
+// that carry no metadata and must always be kept.
+func jpegSegmentKind(marker byte, segment []byte) (Kind, bool) {
+	payload := segment[4:]
+
+	switch {
+	case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+		return KindEXIF, true
+	case marker == markerAPP1 && (bytes.HasPrefix(payload, xmpHeader) || bytes.HasPrefix(payload, xmpExtHdr)):
+		return KindXMP, true
+	case marker == markerAPP2 && bytes.HasPrefix(payload, iccHeader):
+		return KindICC, true
+	case marker == markerAPP13:
+		return KindIPTC, true
+	case marker == markerCOM:
+		return KindComment, true
+	case marker == markerAPP0 || marker == markerAPP14:
+		// JFIF and Adobe segments describe how to decode the image
+		return "", false
+	case marker >= markerAPP1 && marker <= markerAPP15:
+		// Other application segments are never kept
+		return "", true
+	}
+
+	return "", false
+}
+
+// stripJPEG copies the JPEG without the metadata segments that are not whitelisted.
+func stripJPEG(data []byte, keep Whitelist, resetOrientation bool) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	scan, err := walkJPEG(data, func(marker byte, segment []byte) {
+		kind, ok := jpegSegmentKind(marker, segment)
+		if ok && (kind == "" || !keep[kind]) {
+			return
+		}
+
+		start := len(out)
+		out = append(out, segment...)
+		if kind == KindEXIF {
+			sanitizeEXIF(out[start+4+len(exifHeader):], keep, resetOrientation)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(out, data[scan:]...), nil
+}
+
+// jpegEXIF returns the TIFF structure of the EXIF segment of a JPEG image.
+func jpegEXIF(data []byte) []byte {
+	var tiff []byte
+	_, _ = walkJPEG(data, func(marker byte, segment []byte) {
+		if tiff == nil && marker == markerAPP1 && bytes.HasPrefix(segment[4:], exifHeader) {
+			tiff = segment[4+len(exifHeader):]
+		}
+	})
+	return tiff
+}
@@ -0,0 +1,104 @@
+// Package metadata reads the EXIF orientation of images and strips their embedded metadata.
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kind is a kind of embedded image metadata that can be whitelisted.
+type Kind string
+
+const (
+	// KindEXIF is the EXIF block of JPEG and PNG images.
+	KindEXIF Kind = "exif"
+	// KindGPS is the GPS section of the EXIF block. It is only kept together with KindEXIF.
+	KindGPS Kind = "gps"
+	// KindXMP is the XMP packet of JPEG and PNG images.
+	KindXMP Kind = "xmp"
+	// KindICC is the embedded ICC color profile.
+	KindICC Kind = "icc"
+	// KindIPTC is the Photoshop IPTC block of JPEG images.
+	KindIPTC Kind = "iptc"
+	// KindComment covers JPEG comments and PNG text chunks.
+	KindComment Kind = "comment"
+)
+
+// ErrUnsupportedFormat is returned when the metadata of an image format cannot be stripped losslessly.
+var ErrUnsupportedFormat = errors.New("metadata: unsupported format")
+
+// Whitelist is the set of metadata kinds that are kept when stripping.
+type Whitelist map[Kind]bool
+
+// ParseWhitelist parses a comma separated list of metadata kinds.
+func ParseWhitelist(s string) (Whitelist, error) {
+	w := Whitelist{}
+	for _, item := range strings.Split(s, ",") {
+		kind := Kind(strings.ToLower(strings.TrimSpace(item)))
+		switch kind {
+		case "":
+			continue
+		case KindEXIF, KindGPS, KindXMP, KindICC, KindIPTC, KindComment:
+			w[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown metadata kind %q", item)
+		}
+	}
+	return w, nil
+}
+
+// Strip removes the metadata that is not whitelisted from a JPEG or PNG image
+// without re-encoding its pixels. The EXIF orientation is left untouched.
+func Strip(data []byte, keep Whitelist) ([]byte, error) {
+	switch {
+	case isJPEG(data):
+		return stripJPEG(data, keep, false)
+	case isPNG(data):
+		return stripPNG(data, keep)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// Segments returns the whitelisted metadata segments of a JPEG image ready to be
+// inserted into a re-encoded copy with Insert. The EXIF orientation of the returned
+// segments is reset, since the pixels of the copy are expected to be oriented already.
+func Segments(data []byte, keep Whitelist) [][]byte {
+	if !isJPEG(data) || len(keep) == 0 {
+		return nil
+	}
+
+	var segments [][]byte
+	_, _ = walkJPEG(data, func(marker byte, segment []byte) {
+		kind, ok := jpegSegmentKind(marker, segment)
+		if !ok || !keep[kind] {
+			return
+		}
+		segment = append([]byte(nil), segment...)
+		if kind == KindEXIF {
+			sanitizeEXIF(segment[4+len(exifHeader):], keep, true)
+		}
+		segments = append(segments, segment)
+	})
+
+	return segments
+}
+
+// Insert adds the segments to a JPEG image right after its start of image marker.
+func Insert(data []byte, segments [][]byte) []byte {
+	if !isJPEG(data) || len(segments) == 0 {
+		return data
+	}
+
+	size := len(data)
+	for _, s := range segments {
+		size += len(s)
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
@@ -0,0 +1,375 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gpsValue fills the GPS latitude of the test EXIF blocks, so that wiping it can be detected.
+var gpsValue = bytes.Repeat([]byte{0xaa}, 24)
+
+// buildTIFF returns the TIFF structure of an EXIF block with the orientation, when it is not 0,
+// and a GPS directory holding gpsValue.
+func buildTIFF(order binary.ByteOrder, orientation int) []byte {
+	var entries int
+	if orientation != 0 {
+		entries++
+	}
+	entries++ // GPS pointer
+
+	ifd0 := 8
+	gpsIFD := ifd0 + 2 + entries*12 + 4
+	gpsData := gpsIFD + 2 + 12 + 4
+	tiff := make([]byte, gpsData+len(gpsValue))
+
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], uint32(ifd0))
+
+	order.PutUint16(tiff[ifd0:], uint16(entries))
+	pos := ifd0 + 2
+	if orientation != 0 {
+		putEntry(order, tiff[pos:], tagOrientation, 3, 1)
+		order.PutUint16(tiff[pos+8:], uint16(orientation))
+		pos += 12
+	}
+	putEntry(order, tiff[pos:], tagGPSIFD, 4, 1)
+	order.PutUint32(tiff[pos+8:], uint32(gpsIFD))
+
+	// A GPS latitude of three rationals stored after the directory
+	order.PutUint16(tiff[gpsIFD:], 1)
+	putEntry(order, tiff[gpsIFD+2:], 0x0002, 5, 3)
+	order.PutUint32(tiff[gpsIFD+2+8:], uint32(gpsData))
+	copy(tiff[gpsData:], gpsValue)
+
+	return tiff
+}
+
+func putEntry(order binary.ByteOrder, b []byte, tag, fieldType uint16, count uint32) {
+	order.PutUint16(b, tag)
+	order.PutUint16(b[2:], fieldType)
+	order.PutUint32(b[4:], count)
+}
+
+// segment returns a JPEG marker segment with the payload.
+func segment(marker byte, payload []byte) []byte {
+	s := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:], uint16(2+len(payload)))
+	return append(s, payload...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return segment(markerAPP1, append(append([]byte(nil), exifHeader...), tiff...))
+}
+
+// chunk returns a PNG chunk with its CRC.
+func chunk(chunkType string, data []byte) []byte {
+	c := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(c, uint32(len(data)))
+	copy(c[4:], chunkType)
+	c = append(c, data...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodePNG returns a PNG with the chunks inserted after its header chunk.
+func encodePNG(t *testing.T, chunks ...[]byte) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestParseWhitelist(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Whitelist
+		wantErr bool
+	}{
+		{in: "", want: Whitelist{}},
+		{in: "exif", want: Whitelist{KindEXIF: true}},
+		{in: " EXIF , gps,,icc", want: Whitelist{KindEXIF: true, KindGPS: true, KindICC: true}},
+		{in: "exif,location", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseWhitelist(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWhitelist(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !equalWhitelists(got, tt.want) {
+			t.Errorf("ParseWhitelist(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func equalWhitelists(a, b Whitelist) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for kind := range a {
+		if !b[kind] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOrientation(t *testing.T) {
+	jpg := encodeJPEG(t)
+	le := buildTIFF(binary.LittleEndian, 6)
+
+	badCount := buildTIFF(binary.LittleEndian, 6)
+	binary.LittleEndian.PutUint16(badCount[8:], 0xffff)
+
+	badIFDOffset := buildTIFF(binary.BigEndian, 6)
+	binary.BigEndian.PutUint32(badIFDOffset[4:], 0xfffffff0)
+
+	wrongType := buildTIFF(binary.LittleEndian, 6)
+	binary.LittleEndian.PutUint16(wrongType[8+2+2:], 4)
+
+	badOrder := buildTIFF(binary.LittleEndian, 6)
+	copy(badOrder, "XX")
+
+	badMagic := buildTIFF(binary.LittleEndian, 6)
+	binary.LittleEndian.PutUint16(badMagic[2:], 43)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"jpeg little endian", Insert(jpg, [][]byte{exifSegment(le)}), 6},
+		{"jpeg big endian", Insert(jpg, [][]byte{exifSegment(buildTIFF(binary.BigEndian, 8))}), 8},
+		{"png", encodePNG(t, chunk("eXIf", buildTIFF(binary.BigEndian, 3))), 3},
+		{"tiff", buildTIFF(binary.LittleEndian, 5), 5},
+		{"no exif", jpg, 1},
+		{"no orientation", Insert(jpg, [][]byte{exifSegment(buildTIFF(binary.LittleEndian, 0))}), 1},
+		{"orientation out of range", Insert(jpg, [][]byte{exifSegment(buildTIFF(binary.LittleEndian, 9))}), 1},
+		{"wrong orientation type", Insert(jpg, [][]byte{exifSegment(wrongType)}), 1},
+		{"entry count past the end", Insert(jpg, [][]byte{exifSegment(badCount)}), 1},
+		{"directory offset past the end", Insert(jpg, [][]byte{exifSegment(badIFDOffset)}), 1},
+		{"unknown byte order", Insert(jpg, [][]byte{exifSegment(badOrder)}), 1},
+		{"bad magic", Insert(jpg, [][]byte{exifSegment(badMagic)}), 1},
+		{"empty exif", Insert(jpg, [][]byte{exifSegment(nil)}), 1},
+		{"truncated tiff header", Insert(jpg, [][]byte{exifSegment(le[:6])}), 1},
+		{"truncated directory", Insert(jpg, [][]byte{exifSegment(le[:16])}), 1},
+		{"segment length past the end", append([]byte{0xff, markerSOI, 0xff, markerAPP1, 0xff, 0xff}, exifHeader...), 1},
+		{"png eXIf after IDAT", append(encodePNG(t), chunk("eXIf", le)...), 1},
+		{"png chunk length past the end", append(append([]byte(nil), pngSignature...), 0x7f, 0xff, 0xff, 0xff, 'e', 'X', 'I', 'f'), 1},
+		{"empty", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(tt.data); got != tt.want {
+				t.Errorf("Orientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTruncated feeds every prefix of images with metadata to the parsers, which must fail or
+// ignore the metadata without panicking.
+func TestTruncated(t *testing.T) {
+	tiff := buildTIFF(binary.BigEndian, 6)
+	images := map[string][]byte{
+		"jpeg": Insert(encodeJPEG(t), [][]byte{exifSegment(tiff), segment(markerCOM, []byte("comment"))}),
+		"png":  encodePNG(t, chunk("eXIf", tiff), chunk("tEXt", []byte("Comment\x00text"))),
+		"tiff": tiff,
+	}
+	keeps := []Whitelist{{}, {KindEXIF: true}, {KindEXIF: true, KindGPS: true}}
+
+	for name, data := range images {
+		for n := 0; n < len(data); n++ {
+			prefix := append([]byte(nil), data[:n]...)
+
+			if o := Orientation(prefix); o < 1 || o > 8 {
+				t.Fatalf("%s[:%d]: Orientation() = %d", name, n, o)
+			}
+			for _, keep := range keeps {
+				_, _ = Strip(append([]byte(nil), prefix...), keep)
+				_ = Segments(prefix, keep)
+			}
+		}
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	jpg := encodeJPEG(t)
+	src := Insert(jpg, [][]byte{
+		exifSegment(buildTIFF(binary.LittleEndian, 6)),
+		segment(markerAPP1, append(append([]byte(nil), xmpHeader...), "<x:xmpmeta/>"...)),
+		segment(markerAPP13, []byte("Photoshop 3.0\x00iptc")),
+		segment(markerCOM, []byte("a comment")),
+	})
+
+	tests := []struct {
+		name     string
+		keep     Whitelist
+		wantEXIF bool
+		wantGPS  bool
+		wantXMP  bool
+		wantIPTC bool
+		wantCOM  bool
+	}{
+		{name: "strip all", keep: Whitelist{}},
+		{name: "keep exif without gps", keep: Whitelist{KindEXIF: true}, wantEXIF: true},
+		{name: "keep exif with gps", keep: Whitelist{KindEXIF: true, KindGPS: true}, wantEXIF: true, wantGPS: true},
+		{name: "gps alone is not kept", keep: Whitelist{KindGPS: true}},
+		{name: "keep xmp and comments", keep: Whitelist{KindXMP: true, KindComment: true}, wantXMP: true, wantCOM: true},
+		{name: "keep iptc", keep: Whitelist{KindIPTC: true}, wantIPTC: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Strip(append([]byte(nil), src...), tt.keep)
+			if err != nil {
+				t.Fatalf("Strip: %v", err)
+			}
+			if _, err = jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("the stripped image does not decode: %v", err)
+			}
+
+			checks := []struct {
+				what string
+				got  bool
+				want bool
+			}{
+				{"exif", bytes.Contains(out, exifHeader), tt.wantEXIF},
+				{"gps", bytes.Contains(out, gpsValue), tt.wantGPS},
+				{"xmp", bytes.Contains(out, xmpHeader), tt.wantXMP},
+				{"iptc", bytes.Contains(out, []byte("Photoshop 3.0")), tt.wantIPTC},
+				{"comment", bytes.Contains(out, []byte("a comment")), tt.wantCOM},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s kept = %v, want %v", c.what, c.got, c.want)
+				}
+			}
+			if tt.wantEXIF && Orientation(out) != 6 {
+				t.Errorf("Orientation() = %d, want the orientation kept", Orientation(out))
+			}
+		})
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+	jpg := encodeJPEG(t)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not an image", []byte("GIF89a")},
+		{"jpeg without scan", jpg[:2]},
+		{"jpeg missing marker", append([]byte{0xff, markerSOI, 0x00, 0x00}, jpg[2:]...)},
+		{"jpeg segment length too short", append([]byte{0xff, markerSOI, 0xff, markerCOM, 0x00, 0x01}, jpg[2:]...)},
+		{"jpeg segment length past the end", []byte{0xff, markerSOI, 0xff, markerCOM, 0x10, 0x00, 'x'}},
+		{"png truncated chunk", append(append([]byte(nil), pngSignature...), 0, 0, 0, 1, 't')},
+		{"png chunk length past the end", append(append([]byte(nil), pngSignature...), chunk("tEXt", []byte("abc"))[:10]...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Strip(tt.data, Whitelist{}); err == nil {
+				t.Error("Strip succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	src := encodePNG(t,
+		chunk("eXIf", buildTIFF(binary.BigEndian, 6)),
+		chunk("tEXt", []byte("Comment\x00text")),
+		chunk("tIME", make([]byte, 7)),
+	)
+
+	tests := []struct {
+		name     string
+		keep     Whitelist
+		wantEXIF bool
+		wantGPS  bool
+		wantText bool
+	}{
+		{name: "strip all", keep: Whitelist{}},
+		{name: "keep exif without gps", keep: Whitelist{KindEXIF: true}, wantEXIF: true},
+		{name: "keep exif with gps", keep: Whitelist{KindEXIF: true, KindGPS: true}, wantEXIF: true, wantGPS: true},
+		{name: "keep comments", keep: Whitelist{KindComment: true}, wantText: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Strip(src, tt.keep)
+			if err != nil {
+				t.Fatalf("Strip: %v", err)
+			}
+			// The decoder verifies the CRC of the rewritten eXIf chunk
+			if _, err = png.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("the stripped image does not decode: %v", err)
+			}
+
+			if got := bytes.Contains(out, []byte("eXIf")); got != tt.wantEXIF {
+				t.Errorf("exif kept = %v, want %v", got, tt.wantEXIF)
+			}
+			if got := bytes.Contains(out, gpsValue); got != tt.wantGPS {
+				t.Errorf("gps kept = %v, want %v", got, tt.wantGPS)
+			}
+			if got := bytes.Contains(out, []byte("tEXt")); got != tt.wantText {
+				t.Errorf("text kept = %v, want %v", got, tt.wantText)
+			}
+			if bytes.Contains(out, []byte("tIME")) {
+				t.Error("the modification time was kept")
+			}
+		})
+	}
+}
+
+func TestSegmentsResetOrientation(t *testing.T) {
+	src := Insert(encodeJPEG(t), [][]byte{
+		exifSegment(buildTIFF(binary.LittleEndian, 6)),
+		segment(markerCOM, []byte("a comment")),
+	})
+
+	segments := Segments(src, Whitelist{KindEXIF: true})
+	if len(segments) != 1 {
+		t.Fatalf("Segments() returned %d segments, want 1", len(segments))
+	}
+
+	out := Insert(encodeJPEG(t), segments)
+	if o := Orientation(out); o != 1 {
+		t.Errorf("Orientation() = %d, want the orientation reset to 1", o)
+	}
+	if bytes.Contains(out, gpsValue) {
+		t.Error("the GPS directory was kept")
+	}
+	if Orientation(src) != 6 {
+		t.Error("Segments modified the source image")
+	}
+}
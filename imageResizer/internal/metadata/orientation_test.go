@@ -0,0 +1,49 @@
+package metadata
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// A 3x2 image whose top-left and top-right pixels are marked
+	const w, h = 3, 2
+	topLeft := color.NRGBA{255, 0, 0, 255}
+	topRight := color.NRGBA{0, 255, 0, 255}
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	src.SetNRGBA(0, 0, topLeft)
+	src.SetNRGBA(w-1, 0, topRight)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		topLeft     image.Point
+		topRight    image.Point
+	}{
+		{0, image.Pt(w, h), image.Pt(0, 0), image.Pt(w-1, 0)},
+		{1, image.Pt(w, h), image.Pt(0, 0), image.Pt(w-1, 0)},
+		{2, image.Pt(w, h), image.Pt(w-1, 0), image.Pt(0, 0)},
+		{3, image.Pt(w, h), image.Pt(w-1, h-1), image.Pt(0, h-1)},
+		{4, image.Pt(w, h), image.Pt(0, h-1), image.Pt(w-1, h-1)},
+		{5, image.Pt(h, w), image.Pt(0, 0), image.Pt(0, w-1)},
+		{6, image.Pt(h, w), image.Pt(h-1, 0), image.Pt(h-1, w-1)},
+		{7, image.Pt(h, w), image.Pt(h-1, w-1), image.Pt(h-1, 0)},
+		{8, image.Pt(h, w), image.Pt(0, w-1), image.Pt(0, 0)},
+		{9, image.Pt(w, h), image.Pt(0, 0), image.Pt(w-1, 0)},
+	}
+
+	for _, tt := range tests {
+		got := ApplyOrientation(src, tt.orientation)
+		if size := got.Bounds().Size(); size != tt.size {
+			t.Errorf("orientation %d: size %v, want %v", tt.orientation, size, tt.size)
+			continue
+		}
+		if c := color.NRGBAModel.Convert(got.At(tt.topLeft.X, tt.topLeft.Y)); c != topLeft {
+			t.Errorf("orientation %d: the top-left pixel is not at %v", tt.orientation, tt.topLeft)
+		}
+		if c := color.NRGBAModel.Convert(got.At(tt.topRight.X, tt.topRight.Y)); c != topRight {
+			t.Errorf("orientation %d: the top-right pixel is not at %v", tt.orientation, tt.topRight)
+		}
+	}
+}
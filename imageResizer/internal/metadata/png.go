@@ -0,0 +1,99 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var errInvalidPNG = errors.New("metadata: invalid PNG")
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// pngChunkKind classifies a chunk. It returns false for the chunks that carry no metadata.
+func pngChunkKind(chunkType string, chunkData []byte) (Kind, bool) {
+	switch chunkType {
+	case "eXIf":
+		return KindEXIF, true
+	case "iCCP":
+		return KindICC, true
+	case "iTXt":
+		if bytes.HasPrefix(chunkData, []byte("XML:com.adobe.xmp\x00")) {
+			return KindXMP, true
+		}
+		return KindComment, true
+	case "tEXt", "zTXt":
+		return KindComment, true
+	case "tIME":
+		// The modification time is never kept
+		return "", true
+	}
+	return "", false
+}
+
+// stripPNG copies the PNG without the metadata chunks that are not whitelisted.
+func stripPNG(data []byte, keep Whitelist) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, errInvalidPNG
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errInvalidPNG
+		}
+
+		chunkType := string(data[pos+4 : pos+8])
+		chunkData := data[pos+8 : pos+8+length]
+
+		kind, ok := pngChunkKind(chunkType, chunkData)
+		if !ok || (kind != "" && keep[kind]) {
+			start := len(out)
+			out = append(out, data[pos:end]...)
+			if kind == KindEXIF && !keep[KindGPS] {
+				sanitizeEXIF(out[start+8:start+8+length], keep, false)
+				// The chunk data changed, so its CRC has to be recomputed
+				binary.BigEndian.PutUint32(out[start+8+length:], crc32.ChecksumIEEE(out[start+4:start+8+length]))
+			}
+		}
+
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// pngEXIF returns the TIFF structure of the eXIf chunk of a PNG image.
+func pngEXIF(data []byte) []byte {
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+
+		switch string(data[pos+4 : pos+8]) {
+		case "eXIf":
+			return data[pos+8 : pos+8+length]
+		case "IDAT", "IEND":
+			// The eXIf chunk must come before the image data
+			return nil
+		}
+
+		pos = end
+	}
+	return nil
+}
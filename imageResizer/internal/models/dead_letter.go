@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// DeadLetter is published to the dead-letter topic for a message that could not be processed.
+type DeadLetter struct {
+	Key            []byte            `json:"key"`
+	Value          []byte            `json:"value"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Topic          string            `json:"topic"`
+	Partition      int               `json:"partition"`
+	Offset         int64             `json:"offset"`
+	Error          string            `json:"error"`
+	Permanent      bool              `json:"permanent"`
+	Attempts       int               `json:"attempts"`
+	MessageTime    time.Time         `json:"message_time"`
+	FirstAttemptAt time.Time         `json:"first_attempt_at"`
+	FailedAt       time.Time         `json:"failed_at"`
+}
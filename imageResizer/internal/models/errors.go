@@ -0,0 +1,6 @@
+package models
+
+import "errors"
+
+// ErrNotFound is returned when an image does not exist in the storage.
+var ErrNotFound = errors.New("image not found")
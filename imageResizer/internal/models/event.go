@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// EventVersion is the version of the result event schema. It is increased on incompatible changes.
+const EventVersion = 1
+
+// Types of the events published to the output topic.
+const (
+	// EventProcessing is published when the resizer starts processing an image
+	EventProcessing = "image.processing"
+	// EventVariantsReady is published when all variants of an image are stored
+	EventVariantsReady = "variants.ready"
+	// EventFailed is published when an image is dead-lettered
+	EventFailed = "image.failed"
+)
+
+// VariantsReadyEvent is published once per processed image, keyed by the original image ID.
+type VariantsReadyEvent struct {
+	Version              int       `json:"version"`
+	Type                 string    `json:"type"`
+	ImageID              string    `json:"image_id"`
+	Variants             []Variant `json:"variants"`
+	ProcessingDurationMs int64     `json:"processing_duration_ms"`
+	ResizerVersion       string    `json:"resizer_version"`
+	ProcessedAt          time.Time `json:"processed_at"`
+}
+
+// StatusEvent reports a processing state change of an image, keyed by the original image ID.
+type StatusEvent struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	ImageID string `json:"image_id"`
+	// Reason is the error of a failed image
+	Reason         string    `json:"reason,omitempty"`
+	ResizerVersion string    `json:"resizer_version"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// Variant describes a stored image variant.
+type Variant struct {
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Format      string `json:"format"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// Checksum is the SHA-256 of the variant content as "sha256:<hex>"
+	Checksum string `json:"checksum"`
+}
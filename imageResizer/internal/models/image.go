@@ -6,8 +6,12 @@ type Image struct {
 	ID          uuid.UUID `json:"id"`
 	CreatedAt   string    `json:"created_at"`
 	Name        string    `json:"name"`
+	Key         string    `json:"key"`
 	URL         string    `json:"url"`
 	ContentType string    `json:"content_type"`
+	Format      string    `json:"format"`
 	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
 	Content     []byte    `json:"-"`
 }
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Supported fit modes of a variant profile.
+const (
+	// FitStretch scales the image to the box ignoring its aspect ratio.
+	FitStretch = "stretch"
+	// FitInside scales the image to fit inside the box preserving its aspect ratio.
+	FitInside = "fit"
+	// FitFill scales the image to cover the box and crops the overflow around the center.
+	FitFill = "fill"
+	// FitPad scales the image to fit inside the box and letterboxes it with the background color.
+	FitPad = "pad"
+	// FitWidth scales the image to the profile width preserving its aspect ratio.
+	FitWidth = "width-only"
+	// FitHeight scales the image to the profile height preserving its aspect ratio.
+	FitHeight = "height-only"
+)
+
+// Supported output formats of a variant profile.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+	// FormatSource keeps the format of the original image.
+	FormatSource = "source"
+)
+
+// FormatExtensions maps the output formats to the file extensions used in the storage keys.
+var FormatExtensions = map[string]string{
+	FormatJPEG: "jpg",
+	FormatPNG:  "png",
+	FormatGIF:  "gif",
+	FormatWebP: "webp",
+}
+
+// SanitizedVariant is the name of the metadata-free copy of the original image.
+// It is reserved and cannot be used as a profile name.
+const SanitizedVariant = "sanitized"
+
+// ReservedVariants are the names that cannot be used as profile names: the copies of the original
+// image and the image routes of the uploader that share the path of the variants.
+var ReservedVariants = map[string]bool{
+	SanitizedVariant: true,
+	"original":       true,
+	"variants":       true,
+	"status":         true,
+	"events":         true,
+}
+
+// VariantProfile describes a single image variant produced by the resizer.
+type VariantProfile struct {
+	Name       string `json:"name"`
+	Width      uint   `json:"width"`
+	Height     uint   `json:"height"`
+	Format     string `json:"format"`
+	Quality    int    `json:"quality"`
+	Fit        string `json:"fit"`
+	Background string `json:"background"`
+}
+
+// BackgroundColor parses the background color of the profile given as #RGB, #RRGGBB or #RRGGBBAA.
+func (p VariantProfile) BackgroundColor() (color.NRGBA, error) {
+	hex := strings.TrimPrefix(p.Background, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid background color %q", p.Background)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid background color %q", p.Background)
+	}
+
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
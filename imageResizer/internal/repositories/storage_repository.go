@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
+	"github.com/demius1992/Image-service/storage"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+type StorageRepository struct {
+	store       storage.Storage
+	keyTemplate string
+}
+
+// NewStorageRepository creates a new instance of the repository. The variants are stored under
+// the keys produced by the key template from the {id}, {variant} and {ext} placeholders.
+func NewStorageRepository(store storage.Storage, keyTemplate string) *StorageRepository {
+	return &StorageRepository{
+		store:       store,
+		keyTemplate: keyTemplate,
+	}
+}
+
+// GetImage downloads an image from the storage using the provided image ID
+func (r *StorageRepository) GetImage(imageID string) (*models.Image, error) {
+	logrus.Println("image requested", imageID)
+
+	object, err := r.store.Get(imageID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("failed to download image %s: %w", imageID, models.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to download image: %v", err)
+	}
+	defer object.Body.Close()
+
+	// Read the variant content
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(object.Body)
+	if err != nil {
+		logrus.Errorf("error occured while reading image data: %s", err.Error())
+		return nil, err
+	}
+
+	// Create and return the image variant model
+	image := &models.Image{
+		Name:        "original",
+		ContentType: object.ContentType,
+		Size:        object.Size,
+		Content:     buf.Bytes(),
+	}
+	return image, nil
+}
+
+// UploadImages uploads the variants of the original image to the storage and sets their keys.
+// The keys only depend on the image ID, variant name and format, so uploading again overwrites them.
+func (r *StorageRepository) UploadImages(imageID string, inputImages []*models.Image) error {
+	for _, image := range inputImages {
+		key := r.variantKey(imageID, image.Name, image.Format)
+
+		// Upload the file to the storage
+		err := r.store.Put(key, bytes.NewReader(image.Content), storage.ObjectInfo{ContentType: image.ContentType})
+		if err != nil {
+			return fmt.Errorf("failed to upload image: %v", err)
+		}
+
+		image.Key = key
+	}
+
+	return nil
+}
+
+// variantKey expands the key template for the variant
+func (r *StorageRepository) variantKey(imageID, variant, format string) string {
+	return strings.NewReplacer(
+		"{id}", imageID,
+		"{variant}", variant,
+		"{ext}", models.FormatExtensions[format],
+	).Replace(r.keyTemplate)
+}
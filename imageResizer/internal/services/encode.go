@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
+	"github.com/demius1992/Image-service/imageResizer/internal/webp"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	// Register the TIFF and WebP decoders for sources in these formats
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// contentTypes maps the supported output formats to their content types.
+var contentTypes = map[string]string{
+	models.FormatJPEG: "image/jpeg",
+	models.FormatPNG:  "image/png",
+	models.FormatGIF:  "image/gif",
+	models.FormatWebP: "image/webp",
+}
+
+// outputFormat resolves the output format of the profile for a source in sourceFormat.
+// Sources in formats that cannot be written fall back to JPEG.
+func outputFormat(profile models.VariantProfile, sourceFormat string) string {
+	if profile.Format != models.FormatSource {
+		return profile.Format
+	}
+	if _, ok := contentTypes[sourceFormat]; ok {
+		return sourceFormat
+	}
+	return models.FormatJPEG
+}
+
+// encodeImage writes the image to w in the given format and returns its content type.
+func encodeImage(w io.Writer, img image.Image, format string, profile models.VariantProfile) (string, error) {
+	var err error
+
+	switch format {
+	case models.FormatJPEG:
+		// JPEG has no alpha channel, so transparent pixels are flattened onto the background
+		if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			img, err = flattenImage(img, profile)
+			if err != nil {
+				return "", err
+			}
+		}
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: profile.Quality})
+	case models.FormatPNG:
+		err = png.Encode(w, img)
+	case models.FormatGIF:
+		err = gif.Encode(w, img, nil)
+	case models.FormatWebP:
+		err = webp.Encode(w, img)
+	default:
+		return "", fmt.Errorf("unsupported output format %q", format)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return contentTypes[format], nil
+}
+
+// flattenImage draws the image over the background color of the profile.
+func flattenImage(img image.Image, profile models.VariantProfile) (image.Image, error) {
+	background, err := profile.BackgroundColor()
+	if err != nil {
+		return nil, err
+	}
+	background.A = 0xff
+
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst, nil
+}
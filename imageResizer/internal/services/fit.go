@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
+	"github.com/nfnt/resize"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// fitImage resizes the image according to the fit mode of the profile.
+func fitImage(img image.Image, profile models.VariantProfile) (image.Image, error) {
+	srcW := float64(img.Bounds().Dx())
+	srcH := float64(img.Bounds().Dy())
+	if srcW == 0 || srcH == 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+
+	boxW := float64(profile.Width)
+	boxH := float64(profile.Height)
+
+	switch profile.Fit {
+	case models.FitStretch:
+		return resize.Resize(profile.Width, profile.Height, img, resize.Lanczos3), nil
+
+	case models.FitWidth:
+		return resize.Resize(profile.Width, 0, img, resize.Lanczos3), nil
+
+	case models.FitHeight:
+		return resize.Resize(0, profile.Height, img, resize.Lanczos3), nil
+
+	case models.FitInside:
+		scale := math.Min(boxW/srcW, boxH/srcH)
+		return scaleImage(img, srcW*scale, srcH*scale), nil
+
+	case models.FitFill:
+		scale := math.Max(boxW/srcW, boxH/srcH)
+		scaled := scaleImage(img, srcW*scale, srcH*scale)
+
+		// Crop the overflow around the center
+		b := scaled.Bounds()
+		offset := image.Pt((b.Dx()-int(profile.Width))/2, (b.Dy()-int(profile.Height))/2)
+		dst := image.NewNRGBA(image.Rect(0, 0, int(profile.Width), int(profile.Height)))
+		draw.Draw(dst, dst.Bounds(), scaled, b.Min.Add(offset), draw.Src)
+		return dst, nil
+
+	case models.FitPad:
+		background, err := profile.BackgroundColor()
+		if err != nil {
+			return nil, err
+		}
+
+		scale := math.Min(boxW/srcW, boxH/srcH)
+		scaled := scaleImage(img, srcW*scale, srcH*scale)
+
+		// Center the scaled image on a canvas filled with the background color
+		b := scaled.Bounds()
+		dst := image.NewNRGBA(image.Rect(0, 0, int(profile.Width), int(profile.Height)))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		offset := image.Pt((int(profile.Width)-b.Dx())/2, (int(profile.Height)-b.Dy())/2)
+		draw.Draw(dst, b.Sub(b.Min).Add(offset), scaled, b.Min, draw.Over)
+		return dst, nil
+	}
+
+	return nil, fmt.Errorf("unsupported fit mode %q", profile.Fit)
+}
+
+// scaleImage resizes the image to the given dimensions rounded to whole pixels.
+func scaleImage(img image.Image, width, height float64) image.Image {
+	w := uint(math.Max(1, math.Round(width)))
+	h := uint(math.Max(1, math.Round(height)))
+	return resize.Resize(w, h, img, resize.Lanczos3)
+}
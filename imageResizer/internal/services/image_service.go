@@ -3,127 +3,435 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageResizer/internal/metadata"
 	"github.com/demius1992/Image-service/imageResizer/internal/models"
-	"github.com/nfnt/resize"
-	"github.com/segmentio/kafka-go"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"image"
-	"image/jpeg"
 	"io"
+	"sync"
+	"time"
 )
 
 type KafkaService interface {
-	GetMessages(ctx context.Context) (*kafka.Message, error)
-	SendMessage(ctx context.Context, ids, urls []string) error
+	FetchMessage(ctx context.Context) (*bus.Message, error)
+	CommitMessages(ctx context.Context, msgs ...*bus.Message) error
+	RejectMessage(ctx context.Context, msg *bus.Message) error
+	SendMessage(ctx context.Context, event *models.VariantsReadyEvent) error
+	SendStatus(ctx context.Context, event *models.StatusEvent) error
+	SendDeadLetter(ctx context.Context, letter *models.DeadLetter) error
 	CreateTopics() error
+	Close() error
 }
 
 type S3ImageRepository interface {
-	GetImage(message *kafka.Message) (*models.Image, error)
-	UploadImages(inputImages []*models.Image) ([]string, []string, error)
+	GetImage(imageID string) (*models.Image, error)
+	UploadImages(imageID string, inputImages []*models.Image) error
 }
 
-type imageSize struct {
-	Width  uint
-	Height uint
-}
+// Version is the resizer version reported in the result events. It is set at build time.
+var Version = "dev"
+
+// sanitizeOriginalHeader is set by the uploader to request a copy of the original without metadata
+const sanitizeOriginalHeader = "sanitize-original"
 
 type ImageService struct {
 	kafkaSrv KafkaService
 	s3Repo   S3ImageRepository
+	profiles []models.VariantProfile
+	metadata metadata.Whitelist
+	pool     PoolConfig
+	retry    RetryPolicy
 }
 
-func NewImageService(kafkaSrv KafkaService, s3Repo S3ImageRepository) *ImageService {
+func NewImageService(kafkaSrv KafkaService, s3Repo S3ImageRepository, profiles []models.VariantProfile,
+	metadataWhitelist metadata.Whitelist, pool PoolConfig, retry RetryPolicy) *ImageService {
+	if pool.Workers < 1 {
+		pool.Workers = 1
+	}
+	if retry.MaxAttempts < 1 {
+		retry.MaxAttempts = 1
+	}
+
 	return &ImageService{
 		kafkaSrv: kafkaSrv,
 		s3Repo:   s3Repo,
+		profiles: profiles,
+		metadata: metadataWhitelist,
+		pool:     pool,
+		retry:    retry,
 	}
 }
 
+// ImageProcessor fetches messages from the message bus and processes them with a pool of workers.
+// A message is processed once its variants are uploaded and the result is published, or it is
+// dead-lettered. The offsets are committed in order per partition once all earlier messages are
+// processed, so messages that were in flight during a crash or a rebalance are processed again.
 func (i *ImageService) ImageProcessor(ctx context.Context) error {
 	err := i.kafkaSrv.CreateTopics()
 	if err != nil {
 		return err
 	}
 
-	logrus.Println("start processing")
-	for {
-		msg, err := i.kafkaSrv.GetMessages(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first error stops the processing
+	errs := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+		cancel()
+	}
+
+	tracker := newOffsetTracker()
+	jobs := make(chan *bus.Message)
+
+	var wg sync.WaitGroup
+	for w := 0; w < i.pool.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				if err := i.processMessage(ctx, msg); err != nil {
+					// The message is redelivered once the processing restarts
+					if rejectErr := i.kafkaSrv.RejectMessage(context.Background(), msg); rejectErr != nil {
+						logrus.Errorf("failed to reject offset %d of partition %d: %v", msg.Offset, msg.Partition, rejectErr)
+					}
+					fail(err)
+					continue
+				}
+
+				// A failed commit only leads to the messages being processed again
+				err := tracker.commitDone(msg, func(last *bus.Message) error {
+					if err := i.kafkaSrv.CommitMessages(ctx, last); err != nil {
+						return fmt.Errorf("failed to commit offset %d of partition %d: %w", last.Offset, last.Partition, err)
+					}
+					return nil
+				})
+				if err != nil {
+					logrus.Error(err)
+				}
+			}
+		}()
+	}
+
+	logrus.Printf("start processing with %d workers", i.pool.Workers)
+	for ctx.Err() == nil {
+		msg, err := i.kafkaSrv.FetchMessage(ctx)
 		if err != nil {
-			if err != io.EOF { // Check if error is not EOF
-				return err
+			if err == io.EOF { // Keep waiting for messages if EOF
+				continue
 			}
-			//logrus.Println("Waiting for messages...")
-			continue // Keep waiting for messages if EOF
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			break
 		}
 
-		if len(msg.Key) <= 0 && len(msg.Value) <= 0 {
-			continue
+		tracker.add(msg)
+		select {
+		case jobs <- msg:
+		case <-ctx.Done():
 		}
+	}
 
-		imageResp, err := i.s3Repo.GetImage(msg)
-		if err != nil {
-			return err
-		}
+	close(jobs)
+	wg.Wait()
 
-		resizeResp, err := resizeImage(imageResp)
-		if err != nil {
-			return err
+	select {
+	case err = <-errs:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+// processMessage creates and uploads the variants of the image referenced by the message.
+// Messages that fail permanently or run out of retries are published to the dead-letter topic.
+func (i *ImageService) processMessage(ctx context.Context, msg *bus.Message) error {
+	if len(msg.Key) <= 0 && len(msg.Value) <= 0 {
+		return nil
+	}
+
+	firstAttemptAt := time.Now().UTC()
+
+	attempts, err := i.handleMessage(ctx, msg)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	logrus.Errorf("failed to process image %s after %d attempts: %v", string(msg.Key), attempts, err)
+
+	letter := &models.DeadLetter{
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        msg.Headers,
+		Topic:          msg.Topic,
+		Partition:      msg.Partition,
+		Offset:         msg.Offset,
+		Error:          err.Error(),
+		Permanent:      IsPermanent(err),
+		Attempts:       attempts,
+		MessageTime:    msg.Time,
+		FirstAttemptAt: firstAttemptAt,
+		FailedAt:       time.Now().UTC(),
+	}
+	_, err = i.retry.do(ctx, "dead-letter publish", func() error {
+		return i.kafkaSrv.SendDeadLetter(ctx, letter)
+	})
+	if err != nil {
+		return err
+	}
+
+	i.sendStatus(ctx, string(msg.Key), models.EventFailed, letter.Error)
+	return nil
+}
+
+// sendStatus publishes a processing state change of the image. The status events only inform
+// the uploader, so a failure is logged instead of stopping the processing.
+func (i *ImageService) sendStatus(ctx context.Context, imageID, eventType, reason string) {
+	if _, err := uuid.Parse(imageID); err != nil {
+		return
+	}
+
+	event := &models.StatusEvent{
+		Version:        models.EventVersion,
+		Type:           eventType,
+		ImageID:        imageID,
+		Reason:         reason,
+		ResizerVersion: Version,
+		OccurredAt:     time.Now().UTC(),
+	}
+	if err := i.kafkaSrv.SendStatus(ctx, event); err != nil {
+		logrus.Errorf("failed to publish %s event of image %s: %v", eventType, imageID, err)
+	}
+}
+
+// handleMessage runs the processing steps of the message, retrying the transient failures.
+// It returns the number of attempts made by the last step.
+func (i *ImageService) handleMessage(ctx context.Context, msg *bus.Message) (int, error) {
+	started := time.Now()
+
+	// The image ID is part of the storage keys of the variants
+	if _, err := uuid.Parse(string(msg.Key)); err != nil {
+		return 1, permanent(fmt.Errorf("invalid image ID %q: %v", string(msg.Key), err))
+	}
+
+	i.sendStatus(ctx, string(msg.Key), models.EventProcessing, "")
+
+	var imageResp *models.Image
+	attempts, err := i.retry.do(ctx, "image download", func() error {
+		var err error
+		imageResp, err = i.s3Repo.GetImage(string(msg.Key))
+		if errors.Is(err, models.ErrNotFound) {
+			return permanent(err)
 		}
+		return err
+	})
+	if err != nil {
+		return attempts, err
+	}
+
+	// Decoding and resizing gives the same result every time, so their failures are permanent
+	config, _, err := image.DecodeConfig(bytes.NewReader(imageResp.Content))
+	if err != nil {
+		return 1, permanent(err)
+	}
+	if err = checkDimensions(config, i.pool); err != nil {
+		return 1, permanent(err)
+	}
+	if err = checkMemoryBudget(config, i.profiles, i.pool.MemoryBudget); err != nil {
+		return 1, permanent(err)
+	}
+
+	resizeResp, err := resizeImage(imageResp, i.profiles, i.metadata)
+	if err != nil {
+		return 1, permanent(err)
+	}
 
-		ids, urls, err := i.s3Repo.UploadImages(resizeResp)
+	if msg.Header(sanitizeOriginalHeader) == "true" {
+		sanitized, err := sanitizeImage(imageResp, i.metadata)
 		if err != nil {
-			return err
+			return 1, permanent(err)
 		}
+		resizeResp = append(resizeResp, sanitized)
+	}
 
-		if err = i.kafkaSrv.SendMessage(ctx, ids, urls); err != nil {
-			return err
-		}
+	attempts, err = i.retry.do(ctx, "variants upload", func() error {
+		return i.s3Repo.UploadImages(string(msg.Key), resizeResp)
+	})
+	if err != nil {
+		return attempts, err
+	}
+
+	event := newVariantsReadyEvent(string(msg.Key), resizeResp, time.Since(started))
+
+	return i.retry.do(ctx, "result publish", func() error {
+		return i.kafkaSrv.SendMessage(ctx, event)
+	})
+}
 
+// newVariantsReadyEvent describes the uploaded variants of the image.
+func newVariantsReadyEvent(imageID string, images []*models.Image, duration time.Duration) *models.VariantsReadyEvent {
+	event := &models.VariantsReadyEvent{
+		Version:              models.EventVersion,
+		Type:                 models.EventVariantsReady,
+		ImageID:              imageID,
+		Variants:             make([]models.Variant, 0, len(images)),
+		ProcessingDurationMs: duration.Milliseconds(),
+		ResizerVersion:       Version,
+		ProcessedAt:          time.Now().UTC(),
 	}
+
+	for _, image := range images {
+		checksum := sha256.Sum256(image.Content)
+		event.Variants = append(event.Variants, models.Variant{
+			Name:        image.Name,
+			Key:         image.Key,
+			Width:       image.Width,
+			Height:      image.Height,
+			Format:      image.Format,
+			ContentType: image.ContentType,
+			Size:        image.Size,
+			Checksum:    "sha256:" + hex.EncodeToString(checksum[:]),
+		})
+	}
+
+	return event
 }
 
-func resizeImage(inputImage *models.Image) ([]*models.Image, error) {
+func resizeImage(inputImage *models.Image, profiles []models.VariantProfile, keep metadata.Whitelist) ([]*models.Image, error) {
 	// Decode the original image
-	img, _, err := image.Decode(bytes.NewReader(inputImage.Content))
+	img, sourceFormat, err := image.Decode(bytes.NewReader(inputImage.Content))
 	if err != nil {
 		return nil, err
 	}
 
-	var images []*models.Image
+	// Rotate and flip the image according to its EXIF orientation
+	img = metadata.ApplyOrientation(img, metadata.Orientation(inputImage.Content))
 
-	sizes := []imageSize{
-		{Width: 320, Height: 240},
-		{Width: 640, Height: 480},
-		{Width: 1280, Height: 960},
-	}
+	// Collect the whitelisted metadata that is carried into the JPEG variants
+	segments := metadata.Segments(inputImage.Content, keep)
 
-	for _, size := range sizes {
-		// Resize the image
-		resized := resize.Resize(size.Width, size.Height, img, resize.Lanczos3)
+	// Create the variants in parallel
+	images := make([]*models.Image, len(profiles))
+	errs := make([]error, len(profiles))
+
+	var wg sync.WaitGroup
+	for idx, profile := range profiles {
+		wg.Add(1)
+		go func(idx int, profile models.VariantProfile) {
+			defer wg.Done()
+			images[idx], errs[idx] = createVariant(img, sourceFormat, profile, segments)
+		}(idx, profile)
+	}
+	wg.Wait()
 
-		// Create a buffer to store the resized image
-		buffer := new(bytes.Buffer)
-		err = jpeg.Encode(buffer, resized, nil)
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		imagesItem := &models.Image{
-			Content: buffer.Bytes(),
-		}
+	return images, nil
+}
+
+// createVariant resizes and encodes the decoded image according to the profile.
+func createVariant(img image.Image, sourceFormat string, profile models.VariantProfile,
+	segments [][]byte) (*models.Image, error) {
+	// Resize the image
+	resized, err := fitImage(img, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a buffer to store the resized image
+	buffer := new(bytes.Buffer)
+	format := outputFormat(profile, sourceFormat)
+	contentType, err := encodeImage(buffer, resized, format, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	content := buffer.Bytes()
+	if format == models.FormatJPEG {
+		content = metadata.Insert(content, segments)
+	}
+
+	return &models.Image{
+		Name:        profile.Name,
+		ContentType: contentType,
+		Format:      format,
+		Size:        int64(len(content)),
+		Width:       resized.Bounds().Dx(),
+		Height:      resized.Bounds().Dy(),
+		Content:     content,
+	}, nil
+}
 
-		switch size.Width {
-		case 320:
-			imagesItem.Name = inputImage.Name + "-small"
-		case 640:
-			imagesItem.Name = inputImage.Name + "-medium"
-		case 1280:
-			imagesItem.Name = inputImage.Name + "-big"
+// sanitizeImage creates a copy of the original image without the metadata that is not whitelisted.
+// JPEG and PNG images are copied losslessly unless their pixels have to be rotated, because the
+// EXIF orientation is dropped; all other images are re-encoded.
+func sanitizeImage(inputImage *models.Image, keep metadata.Whitelist) (*models.Image, error) {
+	config, sourceFormat, err := image.DecodeConfig(bytes.NewReader(inputImage.Content))
+	if err != nil {
+		return nil, err
+	}
+
+	sanitized := &models.Image{
+		Name:   models.SanitizedVariant,
+		Width:  config.Width,
+		Height: config.Height,
+	}
+
+	orientation := metadata.Orientation(inputImage.Content)
+	if orientation == 1 || keep[metadata.KindEXIF] {
+		content, err := metadata.Strip(inputImage.Content, keep)
+		if err == nil {
+			sanitized.Format = sourceFormat
+			sanitized.ContentType = contentTypes[sourceFormat]
+			sanitized.Size = int64(len(content))
+			sanitized.Content = content
+			return sanitized, nil
 		}
+		if err != metadata.ErrUnsupportedFormat {
+			return nil, err
+		}
+	}
 
-		images = append(images, imagesItem)
+	img, _, err := image.Decode(bytes.NewReader(inputImage.Content))
+	if err != nil {
+		return nil, err
 	}
+	img = metadata.ApplyOrientation(img, orientation)
 
-	return images, nil
+	profile := models.VariantProfile{Format: models.FormatSource, Quality: 95, Background: "#ffffff"}
+	format := outputFormat(profile, sourceFormat)
+
+	buffer := new(bytes.Buffer)
+	contentType, err := encodeImage(buffer, img, format, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	content := buffer.Bytes()
+	if format == models.FormatJPEG {
+		content = metadata.Insert(content, metadata.Segments(inputImage.Content, keep))
+	}
+
+	sanitized.Format = format
+	sanitized.ContentType = contentType
+	sanitized.Size = int64(len(content))
+	sanitized.Width = img.Bounds().Dx()
+	sanitized.Height = img.Bounds().Dy()
+	sanitized.Content = content
+	return sanitized, nil
 }
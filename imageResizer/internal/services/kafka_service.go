@@ -2,148 +2,126 @@ package services
 
 import (
 	"context"
-	"github.com/segmentio/kafka-go"
-	"github.com/sirupsen/logrus"
+	"encoding/json"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
 	"log"
-	"net"
 	"strconv"
 )
 
 type kafkaRepo struct {
-	writer      *kafka.Writer
-	reader      *kafka.Reader
-	inputTopic  string
-	outputTopic string
-	s3Repo      S3ImageRepository
+	bus             bus.Bus
+	subscription    bus.Subscription
+	inputTopic      string
+	outputTopic     string
+	deadLetterTopic string
 }
 
-func NewKafkaService(brokers []string, inputTopic, outputTopic string, s3Repo S3ImageRepository) KafkaService {
-	w := &kafka.Writer{
-		Addr:                   kafka.TCP(brokers...),
-		Topic:                  outputTopic,
-		Balancer:               &kafka.LeastBytes{},
-		AllowAutoTopicCreation: true,
+// NewKafkaService creates a service that consumes the input topic of the message bus as a member
+// of the consumer group, so that the messages are shared between the resizer replicas
+func NewKafkaService(messages bus.Bus, inputTopic, outputTopic, deadLetterTopic, groupID string) (KafkaService, error) {
+	subscription, err := messages.Subscribe(inputTopic, groupID)
+	if err != nil {
+		return nil, err
 	}
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  brokers,
-		Topic:    inputTopic,
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
-	})
 
 	return &kafkaRepo{
-		writer:      w,
-		reader:      r,
-		inputTopic:  inputTopic,
-		outputTopic: outputTopic,
-		s3Repo:      s3Repo,
-	}
+		bus:             messages,
+		subscription:    subscription,
+		inputTopic:      inputTopic,
+		outputTopic:     outputTopic,
+		deadLetterTopic: deadLetterTopic,
+	}, nil
 }
 
-// GetMessages gets messages from Kafka
-func (r *kafkaRepo) GetMessages(ctx context.Context) (*kafka.Message, error) {
-	defer func() {
-		if err := r.reader.Close(); err != nil {
-			log.Printf("Failed to close Kafka reader: %v", err)
-		}
-	}()
-
-	msg, err := r.reader.ReadMessage(ctx)
-	if err != nil {
-		return nil, err
-	}
+// FetchMessage fetches the next message without acknowledging it
+func (r *kafkaRepo) FetchMessage(ctx context.Context) (*bus.Message, error) {
+	return r.subscription.Receive(ctx)
+}
 
-	return &msg, nil
+// CommitMessages acknowledges the messages, and the earlier messages of their partitions, for the
+// consumer group
+func (r *kafkaRepo) CommitMessages(ctx context.Context, msgs ...*bus.Message) error {
+	return r.subscription.Ack(ctx, msgs...)
 }
 
-func (r *kafkaRepo) SendMessage(ctx context.Context, ids, urls []string) error {
-	for i, value := range urls {
-		messageKey := []byte(ids[i])
-		messageValue := []byte(value)
+// RejectMessage gives a message that could not be processed back for redelivery
+func (r *kafkaRepo) RejectMessage(ctx context.Context, msg *bus.Message) error {
+	return r.subscription.Nack(ctx, msg)
+}
 
-		err := r.writer.WriteMessages(ctx, kafka.Message{
-			Key:   messageKey,
-			Value: messageValue,
-		})
+// SendDeadLetter publishes a message that could not be processed to the dead-letter topic
+// keyed by the key of the original message
+func (r *kafkaRepo) SendDeadLetter(ctx context.Context, letter *models.DeadLetter) error {
+	value, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Printf("Failed to send message to Kafka: %v", err)
-			return err
-		}
+	err = r.bus.Publish(ctx, r.deadLetterTopic, &bus.Message{
+		Key:   letter.Key,
+		Value: value,
+		Headers: map[string]string{
+			"error":    letter.Error,
+			"attempts": strconv.Itoa(letter.Attempts),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to send dead letter: %v", err)
+		return err
 	}
 	return nil
 }
 
-func (r *kafkaRepo) listTopics() error {
+// Close leaves the consumer group
+func (r *kafkaRepo) Close() error {
+	if err := r.subscription.Close(); err != nil {
+		log.Printf("Failed to close the subscription: %v", err)
+		return err
+	}
+	return nil
+}
 
-	for _, broker := range r.reader.Config().Brokers {
-		conn, err := kafka.Dial("tcp", broker)
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
+// SendMessage publishes the result event of a processed image keyed by the original image ID
+func (r *kafkaRepo) SendMessage(ctx context.Context, event *models.VariantsReadyEvent) error {
+	return r.writeEvent(ctx, event.ImageID, event.Type, event.Version, event)
+}
 
-		partitions, err := conn.ReadPartitions()
-		if err != nil {
-			return err
-		}
+// SendStatus publishes a processing state change of an image keyed by the original image ID, so
+// it is ordered with the result event of the same image
+func (r *kafkaRepo) SendStatus(ctx context.Context, event *models.StatusEvent) error {
+	return r.writeEvent(ctx, event.ImageID, event.Type, event.Version, event)
+}
 
-		m := map[string]struct{}{}
+func (r *kafkaRepo) writeEvent(ctx context.Context, imageID, eventType string, version int, event interface{}) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
 
-		for _, p := range partitions {
-			m[p.Topic] = struct{}{}
-		}
-		for k := range m {
-			logrus.Println(k)
-		}
+	err = r.bus.Publish(ctx, r.outputTopic, &bus.Message{
+		Key:   []byte(imageID),
+		Value: value,
+		Headers: map[string]string{
+			"type":         eventType,
+			"version":      strconv.Itoa(version),
+			"content-type": "application/json",
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to send message: %v", err)
+		return err
 	}
 	return nil
 }
 
+// CreateTopics creates the output and dead-letter topics, for the Kafka clusters where
+// auto.create.topics.enable is false
 func (r *kafkaRepo) CreateTopics() error {
-	brokers := r.reader.Config().Brokers
-	topic := r.outputTopic
-
-	// Create topics in all Kafka nodes
-	for _, brokerAddr := range brokers {
-
-		// to create topics when auto.create.topics.enable='false'
-		conn, err := kafka.Dial("tcp", brokerAddr)
-		if err != nil {
-			panic(err.Error())
-		}
-		defer conn.Close()
-
-		controller, err := conn.Controller()
-		if err != nil {
-			panic(err.Error())
-		}
-		var controllerConn *kafka.Conn
-		controllerConn, err = kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
-		if err != nil {
-			panic(err.Error())
-		}
-		defer controllerConn.Close()
-
-		topicConfigs := []kafka.TopicConfig{
-			{
-				Topic:             topic,
-				NumPartitions:     3,
-				ReplicationFactor: 1,
-			},
-		}
-
-		err = controllerConn.CreateTopics(topicConfigs...)
-		if err != nil {
-			panic(err.Error())
-		}
-
-		log.Printf("Successfully created topic %s on broker %s", topic, brokerAddr)
-	}
-
-	err := r.listTopics()
-	if err != nil {
+	if err := r.bus.CreateTopics(r.outputTopic, r.deadLetterTopic); err != nil {
 		return err
 	}
+
+	log.Printf("Successfully created topics %s and %s", r.outputTopic, r.deadLetterTopic)
 	return nil
 }
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"github.com/demius1992/Image-service/bus"
+	"sync"
+)
+
+// offsetTracker keeps the fetched messages of every partition in order, so that an
+// offset is only committed after all earlier messages of its partition are processed.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	pending []*bus.Message
+	done    map[int64]bool
+	// ready is the last message that can be committed and is not committed yet
+	ready *bus.Message
+	// commitMu sends the commits of the partition one at a time
+	commitMu sync.Mutex
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[int]*partitionOffsets),
+	}
+}
+
+// add registers a fetched message. Messages must be added in the order they were fetched.
+func (t *offsetTracker) add(msg *bus.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[msg.Partition] = p
+	}
+	p.pending = append(p.pending, msg)
+}
+
+// markDone marks the message as processed and returns the last message of the partition
+// that can be committed, or nil if earlier messages are still being processed.
+func (t *offsetTracker) markDone(msg *bus.Message) *bus.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		return nil
+	}
+	p.done[msg.Offset] = true
+
+	var last *bus.Message
+	for len(p.pending) > 0 && p.done[p.pending[0].Offset] {
+		last = p.pending[0]
+		delete(p.done, last.Offset)
+		p.pending = p.pending[1:]
+	}
+	if last != nil {
+		p.ready = last
+	}
+
+	return last
+}
+
+// commitDone marks the message as processed and commits the last message of its partition that
+// can be committed. The commits of a partition are sent one at a time and never go backwards:
+// a worker that waited for an earlier commit sends the latest committable offset, or nothing if
+// it was already committed. A failed commit is retried by the next commit of the partition.
+func (t *offsetTracker) commitDone(msg *bus.Message, commit func(*bus.Message) error) error {
+	if t.markDone(msg) == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	p := t.partitions[msg.Partition]
+	t.mu.Unlock()
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+
+	t.mu.Lock()
+	last := p.ready
+	t.mu.Unlock()
+	if last == nil {
+		return nil
+	}
+
+	if err := commit(last); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if p.ready == last {
+		p.ready = nil
+	}
+	t.mu.Unlock()
+
+	return nil
+}
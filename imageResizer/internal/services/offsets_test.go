@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demius1992/Image-service/bus"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	// A step adds a fetched message, or marks it done and expects the message to commit,
+	// with an offset of -1 when nothing can be committed
+	type step struct {
+		done      bool
+		partition int
+		offset    int64
+		commit    int64
+	}
+	add := func(partition int, offset int64) step {
+		return step{partition: partition, offset: offset}
+	}
+	done := func(partition int, offset, commit int64) step {
+		return step{done: true, partition: partition, offset: offset, commit: commit}
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"in order", []step{
+			add(0, 0), add(0, 1), add(0, 2),
+			done(0, 0, 0), done(0, 1, 1), done(0, 2, 2),
+		}},
+		{"out of order", []step{
+			add(0, 0), add(0, 1), add(0, 2),
+			done(0, 2, -1), done(0, 1, -1), done(0, 0, 2),
+		}},
+		{"gap in the middle", []step{
+			add(0, 0), add(0, 1), add(0, 2), add(0, 3),
+			done(0, 0, 0), done(0, 2, -1), done(0, 3, -1), done(0, 1, 3),
+		}},
+		{"independent partitions", []step{
+			add(0, 0), add(1, 0), add(0, 1), add(1, 1),
+			done(0, 1, -1), done(1, 0, 0), done(0, 0, 1), done(1, 1, 1),
+		}},
+		{"sparse offsets", []step{
+			add(0, 3), add(0, 7), add(0, 9),
+			done(0, 7, -1), done(0, 3, 7), done(0, 9, 9),
+		}},
+		{"messages added after a commit", []step{
+			add(0, 0), done(0, 0, 0),
+			add(0, 1), add(0, 2), done(0, 2, -1), done(0, 1, 2),
+		}},
+		{"unknown partition", []step{
+			add(0, 0), done(1, 0, -1), done(0, 0, 0),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newOffsetTracker()
+			for i, s := range tt.steps {
+				msg := &bus.Message{Partition: s.partition, Offset: s.offset}
+				if !s.done {
+					tracker.add(msg)
+					continue
+				}
+
+				last := tracker.markDone(msg)
+				switch {
+				case s.commit < 0 && last != nil:
+					t.Fatalf("step %d: committed offset %d of partition %d, want nothing", i, last.Offset, last.Partition)
+				case s.commit >= 0 && last == nil:
+					t.Fatalf("step %d: committed nothing, want offset %d", i, s.commit)
+				case s.commit >= 0 && (last.Offset != s.commit || last.Partition != s.partition):
+					t.Fatalf("step %d: committed offset %d of partition %d, want offset %d of partition %d",
+						i, last.Offset, last.Partition, s.commit, s.partition)
+				}
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerConcurrentCommits(t *testing.T) {
+	const (
+		partitions = 3
+		messages   = 200
+		workers    = 8
+	)
+
+	tracker := newOffsetTracker()
+	jobs := make(chan *bus.Message, partitions*messages)
+	for offset := int64(0); offset < messages; offset++ {
+		for partition := 0; partition < partitions; partition++ {
+			msg := &bus.Message{Partition: partition, Offset: offset}
+			tracker.add(msg)
+			jobs <- msg
+		}
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	committed := make(map[int][]int64)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for msg := range jobs {
+				time.Sleep(time.Duration(rnd.Intn(50)) * time.Microsecond)
+				err := tracker.commitDone(msg, func(last *bus.Message) error {
+					// A slow commit lets the other workers catch up with later offsets
+					time.Sleep(time.Duration(rnd.Intn(200)) * time.Microsecond)
+					mu.Lock()
+					committed[last.Partition] = append(committed[last.Partition], last.Offset)
+					mu.Unlock()
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	for partition := 0; partition < partitions; partition++ {
+		offsets := committed[partition]
+		if len(offsets) == 0 || offsets[len(offsets)-1] != messages-1 {
+			t.Fatalf("partition %d committed %v, want the last offset %d", partition, offsets, messages-1)
+		}
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] <= offsets[i-1] {
+				t.Fatalf("partition %d committed offset %d after %d", partition, offsets[i], offsets[i-1])
+			}
+		}
+	}
+}
+
+func TestOffsetTrackerFailedCommit(t *testing.T) {
+	tracker := newOffsetTracker()
+	msgs := []*bus.Message{{Offset: 0}, {Offset: 1}}
+	for _, msg := range msgs {
+		tracker.add(msg)
+	}
+
+	var committed []int64
+	fail := true
+	commit := func(last *bus.Message) error {
+		if fail {
+			fail = false
+			return errors.New("commit failed")
+		}
+		committed = append(committed, last.Offset)
+		return nil
+	}
+
+	if err := tracker.commitDone(msgs[0], commit); err == nil {
+		t.Fatal("commitDone() = nil, want the commit error")
+	}
+	if err := tracker.commitDone(msgs[1], commit); err != nil {
+		t.Fatal(err)
+	}
+	if len(committed) != 1 || committed[0] != 1 {
+		t.Errorf("committed %v, want [1]", committed)
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"fmt"
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
+	"image"
+)
+
+// bytesPerPixel is the memory used by a decoded pixel in the widest image representation.
+const bytesPerPixel = 4
+
+// PoolConfig configures the worker pool of the ImageProcessor.
+type PoolConfig struct {
+	// Workers is the number of messages processed concurrently
+	Workers int
+	// MemoryBudget is the estimated number of bytes a worker may use for a single image
+	MemoryBudget int64
+	// MaxWidth, MaxHeight and MaxMegapixels bound the declared dimensions of an image, so that
+	// decompression bombs are refused before they are decoded. Zero disables a limit.
+	MaxWidth      int
+	MaxHeight     int
+	MaxMegapixels float64
+}
+
+// checkDimensions fails if the dimensions declared in the image header exceed the limits.
+func checkDimensions(config image.Config, pool PoolConfig) error {
+	if pool.MaxWidth > 0 && config.Width > pool.MaxWidth {
+		return fmt.Errorf("image width %d exceeds %d pixels", config.Width, pool.MaxWidth)
+	}
+	if pool.MaxHeight > 0 && config.Height > pool.MaxHeight {
+		return fmt.Errorf("image height %d exceeds %d pixels", config.Height, pool.MaxHeight)
+	}
+	megapixels := float64(config.Width) * float64(config.Height) / 1e6
+	if pool.MaxMegapixels > 0 && megapixels > pool.MaxMegapixels {
+		return fmt.Errorf("image of %.1f megapixels exceeds %.1f", megapixels, pool.MaxMegapixels)
+	}
+	return nil
+}
+
+// checkMemoryBudget estimates the memory needed to decode, orient and resize the image
+// to all profiles and fails if it exceeds the budget.
+func checkMemoryBudget(config image.Config, profiles []models.VariantProfile, budget int64) error {
+	if budget <= 0 {
+		return nil
+	}
+
+	srcPixels := int64(config.Width) * int64(config.Height)
+
+	// The decoded image and its oriented copy
+	estimate := 2 * srcPixels * bytesPerPixel
+
+	// The variants are resized in parallel, each through an intermediate image
+	for _, profile := range profiles {
+		estimate += 2 * variantPixels(config, profile) * bytesPerPixel
+	}
+
+	if estimate > budget {
+		return fmt.Errorf("image of %dx%d pixels needs about %d MB, over the worker memory budget of %d MB",
+			config.Width, config.Height, estimate>>20, budget>>20)
+	}
+
+	return nil
+}
+
+// variantPixels estimates the number of pixels of the variant produced by the profile.
+func variantPixels(config image.Config, profile models.VariantProfile) int64 {
+	w, h := int64(profile.Width), int64(profile.Height)
+	srcW, srcH := int64(config.Width), int64(config.Height)
+	if srcW == 0 || srcH == 0 {
+		return 0
+	}
+
+	switch profile.Fit {
+	case models.FitWidth:
+		h = w * srcH / srcW
+	case models.FitHeight:
+		w = h * srcW / srcH
+	case models.FitFill:
+		// The image is scaled to cover the box before it is cropped
+		if w*srcH > h*srcW {
+			h = w * srcH / srcW
+		} else {
+			w = h * srcW / srcH
+		}
+	}
+
+	return w * h
+}
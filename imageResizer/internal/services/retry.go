@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"time"
+)
+
+// PermanentError marks a failure that does not go away when retried, such as a missing or corrupt image.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// permanent marks the error as permanent.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether the error is permanent. All other errors are considered transient.
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
+
+// RetryPolicy configures the retries of transient failures with exponential backoff.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// do runs fn until it succeeds, fails permanently or runs out of attempts.
+// It returns the number of attempts made.
+func (p RetryPolicy) do(ctx context.Context, operation string, fn func() error) (int, error) {
+	backoff := p.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || IsPermanent(err) || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+
+		// Wait between half and the full backoff to spread the retries of the workers
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		logrus.Warnf("%s failed on attempt %d, retrying in %s: %v", operation, attempt, wait, err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Package webp implements a lossless WebP (VP8L) encoder.
+//
+// The encoder applies the subtract-green transform and entropy codes the pixels with
+// one Huffman code per channel. It does not use backward references or a color cache,
+// which keeps it small at the cost of larger files than libwebp produces.
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// maxDimension is the largest width or height representable in a VP8L header.
+const maxDimension = 1 << 14
+
+const (
+	// maxCodeLength is the longest Huffman code allowed for the pixel alphabets.
+	maxCodeLength = 15
+	// maxCodeLengthCodeLength is the longest Huffman code allowed for the code length alphabet.
+	maxCodeLengthCodeLength = 7
+	// numCodeLengthCodes is the size of the code length alphabet.
+	numCodeLengthCodes = 19
+	// numLengthCodes is the number of backward reference length prefix codes in the green alphabet.
+	numLengthCodes = 24
+	// numDistanceCodes is the size of the distance alphabet.
+	numDistanceCodes = 40
+)
+
+// codeLengthCodeOrder is the order in which the code length code lengths are written.
+var codeLengthCodeOrder = [numCodeLengthCodes]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Encode writes the image m to w in the lossless WebP format.
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return errors.New("webp: invalid image dimensions")
+	}
+
+	// Collect the pixels with the subtract-green transform applied
+	var hasAlpha bool
+	pixels := make([][4]byte, 0, width*height)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			pixels = append(pixels, [4]byte{c.G, c.R - c.G, c.B - c.G, c.A})
+		}
+	}
+
+	// Build one code per channel: green, red, blue and alpha
+	var histograms [4][]int
+	histograms[0] = make([]int, 256+numLengthCodes)
+	for i := 1; i < 4; i++ {
+		histograms[i] = make([]int, 256)
+	}
+	for _, p := range pixels {
+		for i, v := range p {
+			histograms[i][v]++
+		}
+	}
+
+	bw := &bitWriter{}
+
+	// Header: signature, dimensions, alpha hint and version
+	bw.writeBits(0x2f, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3)
+
+	// A single subtract-green transform
+	bw.writeBits(1, 1)
+	bw.writeBits(2, 2)
+	bw.writeBits(0, 1)
+
+	// No color cache and no meta prefix codes
+	bw.writeBits(0, 1)
+	bw.writeBits(0, 1)
+
+	var codes [4][]code
+	for i, histogram := range histograms {
+		codes[i] = writePrefixCode(bw, histogram)
+	}
+	// The distance code is never used
+	writePrefixCode(bw, make([]int, numDistanceCodes))
+
+	for _, p := range pixels {
+		for i, v := range p {
+			bw.writeCode(codes[i][v])
+		}
+	}
+
+	data := bw.bytes()
+
+	// RIFF container with a single VP8L chunk
+	chunkSize := len(data)
+	padding := chunkSize & 1
+	buf := new(bytes.Buffer)
+	buf.WriteString("RIFF")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(4+8+chunkSize+padding))
+	buf.WriteString("WEBPVP8L")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(chunkSize))
+	buf.Write(data)
+	if padding == 1 {
+		buf.WriteByte(0)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// code is a Huffman code with its bits already reversed for the LSB-first bit writer.
+type code struct {
+	bits   uint32
+	length uint
+}
+
+// writePrefixCode writes the prefix code for the histogram and returns the codes of its symbols.
+func writePrefixCode(bw *bitWriter, histogram []int) []code {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	codes := make([]code, len(histogram))
+
+	// Up to two symbols below 256 fit the simple code
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.writeBits(1, 1)
+		bw.writeBits(uint32(len(used)-1), 1)
+		for i, symbol := range used {
+			if i == 0 {
+				if symbol < 2 {
+					bw.writeBits(0, 1)
+					bw.writeBits(uint32(symbol), 1)
+				} else {
+					bw.writeBits(1, 1)
+					bw.writeBits(uint32(symbol), 8)
+				}
+			} else {
+				bw.writeBits(uint32(symbol), 8)
+			}
+		}
+		if len(used) == 2 {
+			codes[used[0]] = code{bits: 0, length: 1}
+			codes[used[1]] = code{bits: 1, length: 1}
+		}
+		return codes
+	}
+
+	lengths := codeLengths(histogram, maxCodeLength)
+
+	// Encode the code lengths with the code length code
+	clHistogram := make([]int, numCodeLengthCodes)
+	for _, l := range lengths {
+		clHistogram[l]++
+	}
+	ensureTwoSymbols(clHistogram)
+	clLengths := codeLengths(clHistogram, maxCodeLengthCodeLength)
+	clCodes := canonicalCodes(clLengths)
+
+	numCodes := 4
+	for i := numCodeLengthCodes - 1; i >= 4; i-- {
+		if clLengths[codeLengthCodeOrder[i]] != 0 {
+			numCodes = i + 1
+			break
+		}
+	}
+
+	bw.writeBits(0, 1)
+	bw.writeBits(uint32(numCodes-4), 4)
+	for i := 0; i < numCodes; i++ {
+		bw.writeBits(uint32(clLengths[codeLengthCodeOrder[i]]), 3)
+	}
+	// The code lengths cover the whole alphabet
+	bw.writeBits(0, 1)
+	for _, l := range lengths {
+		bw.writeCode(clCodes[l])
+	}
+
+	return canonicalCodes(lengths)
+}
+
+// ensureTwoSymbols makes sure that at least two symbols of the histogram are used,
+// so that every Huffman code built from it has codes of at least one bit.
+func ensureTwoSymbols(histogram []int) {
+	var used int
+	for _, count := range histogram {
+		if count > 0 {
+			used++
+		}
+	}
+	for symbol := 0; used < 2; symbol++ {
+		if histogram[symbol] == 0 {
+			histogram[symbol] = 1
+			used++
+		}
+	}
+}
+
+// codeLengths computes Huffman code lengths for the histogram limited to maxLength bits.
+// The counts are halved until the limit holds.
+func codeLengths(histogram []int, maxLength int) []int {
+	counts := append([]int(nil), histogram...)
+	for {
+		lengths := huffmanLengths(counts)
+
+		longest := 0
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= maxLength {
+			return lengths
+		}
+
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanLengths computes unrestricted Huffman code lengths for the histogram.
+func huffmanLengths(histogram []int) []int {
+	type node struct {
+		count       int
+		symbol      int
+		left, right *node
+	}
+
+	var nodes []*node
+	for symbol, count := range histogram {
+		if count > 0 {
+			nodes = append(nodes, &node{count: count, symbol: symbol})
+		}
+	}
+
+	lengths := make([]int, len(histogram))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbol] = 1
+		return lengths
+	}
+
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		merged := &node{count: nodes[0].count + nodes[1].count, symbol: -1, left: nodes[0], right: nodes[1]}
+		nodes = append([]*node{merged}, nodes[2:]...)
+	}
+
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(nodes[0], 0)
+
+	return lengths
+}
+
+// canonicalCodes assigns canonical Huffman codes to the code lengths.
+func canonicalCodes(lengths []int) []code {
+	var counts [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			counts[l]++
+		}
+	}
+
+	var next [maxCodeLength + 2]uint32
+	for l := 1; l <= maxCodeLength; l++ {
+		next[l+1] = (next[l] + counts[l]) << 1
+	}
+
+	codes := make([]code, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[symbol] = code{bits: reverseBits(next[l], uint(l)), length: uint(l)}
+		next[l]++
+	}
+
+	return codes
+}
+
+// reverseBits reverses the lowest n bits of v.
+func reverseBits(v uint32, n uint) uint32 {
+	var r uint32
+	for i := uint(0); i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+// bitWriter packs bits LSB-first as required by VP8L.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) writeCode(c code) {
+	w.writeBits(c.bits, c.length)
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
@@ -0,0 +1,121 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"single pixel", filled(1, 1, func(x, y int) color.NRGBA { return color.NRGBA{10, 20, 30, 255} })},
+		{"solid", filled(17, 9, func(x, y int) color.NRGBA { return color.NRGBA{200, 100, 50, 255} })},
+		{"gradient", filled(64, 48, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 4), uint8(y * 5), uint8(x + y), 255}
+		})},
+		{"transparency", filled(32, 32, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 8), 128, uint8(y * 8), uint8(x * y)}
+		})},
+		{"noise", noise(50, 40, 1)},
+		// A skewed histogram needs codes longer than the limit before they are rebalanced
+		{"skewed", filled(300, 200, func(x, y int) color.NRGBA {
+			v := uint8(0)
+			for i := x + y*300; i%2 == 1 && v < 30; i /= 2 {
+				v++
+			}
+			return color.NRGBA{v, v, v, 255}
+		})},
+		{"offset bounds", noise(40, 40, 2).SubImage(image.Rect(5, 7, 33, 21))},
+		{"gray", func() image.Image {
+			img := image.NewGray(image.Rect(0, 0, 20, 10))
+			for i := range img.Pix {
+				img.Pix[i] = uint8(i * 7)
+			}
+			return img
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.img); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			decoded, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			b := tt.img.Bounds()
+			if got := decoded.Bounds(); got.Dx() != b.Dx() || got.Dy() != b.Dy() {
+				t.Fatalf("decoded size %v, want %dx%d", got.Size(), b.Dx(), b.Dy())
+			}
+			db := decoded.Bounds()
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(b.Min.X+x, b.Min.Y+y))
+					got := color.NRGBAModel.Convert(decoded.At(db.Min.X+x, db.Min.Y+y))
+					if got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeInvalidDimensions(t *testing.T) {
+	tests := []struct {
+		name string
+		rect image.Rectangle
+	}{
+		{"empty", image.Rect(0, 0, 0, 0)},
+		{"too wide", image.Rect(0, 0, maxDimension+1, 1)},
+		{"too high", image.Rect(0, 0, 1, maxDimension+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the bounds are read before the dimensions are refused
+			img := image.NewUniform(color.White)
+			if err := Encode(&bytes.Buffer{}, boundedImage{img, tt.rect}); err == nil {
+				t.Fatal("Encode succeeded, want an error")
+			}
+		})
+	}
+}
+
+// boundedImage gives an image arbitrary bounds without allocating its pixels.
+type boundedImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+func (m boundedImage) Bounds() image.Rectangle {
+	return m.bounds
+}
+
+func filled(width, height int, at func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, at(x, y))
+		}
+	}
+	return img
+}
+
+func noise(width, height int, seed int64) *image.NRGBA {
+	r := rand.New(rand.NewSource(seed))
+	return filled(width, height, func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256))}
+	})
+}
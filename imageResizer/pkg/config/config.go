@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
+	"github.com/demius1992/Image-service/storage"
+	"image/jpeg"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// profileNameRe restricts profile names to values that are safe to use in storage keys.
+var profileNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// DefaultProfiles are used when no profiles are configured.
+var DefaultProfiles = []models.VariantProfile{
+	{Name: "small", Width: 320, Height: 240},
+	{Name: "medium", Width: 640, Height: 480},
+	{Name: "big", Width: 1280, Height: 960},
+}
+
+// DefaultKeyTemplate is the storage key layout of the variants used when VARIANT_KEY_TEMPLATE is not set.
+const DefaultKeyTemplate = "{id}/{variant}.{ext}"
+
+// LoadKeyTemplate loads the storage key layout of the variants from VARIANT_KEY_TEMPLATE.
+// The template must contain the {id} and {variant} placeholders and may contain {ext}.
+func LoadKeyTemplate() (string, error) {
+	template := os.Getenv("VARIANT_KEY_TEMPLATE")
+	if template == "" {
+		return DefaultKeyTemplate, nil
+	}
+
+	if !strings.Contains(template, "{id}") || !strings.Contains(template, "{variant}") {
+		return "", fmt.Errorf("variant key template %q must contain {id} and {variant}", template)
+	}
+
+	return template, nil
+}
+
+// LoadStorage loads the storage driver configuration from STORAGE_DRIVER, STORAGE_PATH and the
+// S3 settings. The resizer never signs links, so the signing settings are not needed.
+func LoadStorage() storage.Config {
+	return storage.Config{
+		Driver:    os.Getenv("STORAGE_DRIVER"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+		Endpoint:  os.Getenv("ENDPOINT"),
+		AccessKey: os.Getenv("ACCESS_KEY"),
+		SecretKey: os.Getenv("SECRET_KEY"),
+		Path:      os.Getenv("STORAGE_PATH"),
+	}
+}
+
+// LoadBus loads the message bus configuration from MESSAGE_BUS and KAFKA_BROKERS.
+func LoadBus() bus.Config {
+	return bus.Config{
+		Driver:  os.Getenv("MESSAGE_BUS"),
+		Brokers: strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
+	}
+}
+
+// LoadProfiles loads the variant profiles from the JSON file set in VARIANT_PROFILES_FILE
+// or from the inline JSON set in VARIANT_PROFILES. The defaults are used if neither is set.
+func LoadProfiles() ([]models.VariantProfile, error) {
+	var data []byte
+
+	if path := os.Getenv("VARIANT_PROFILES_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variant profiles file: %v", err)
+		}
+		data = content
+	} else if inline := os.Getenv("VARIANT_PROFILES"); inline != "" {
+		data = []byte(inline)
+	}
+
+	profiles := append([]models.VariantProfile(nil), DefaultProfiles...)
+	if data != nil {
+		profiles = nil
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return nil, fmt.Errorf("failed to parse variant profiles: %v", err)
+		}
+	}
+
+	if err := ValidateProfiles(profiles); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// ValidateProfiles checks the profiles and fills in the defaults of the optional fields.
+func ValidateProfiles(profiles []models.VariantProfile) error {
+	if len(profiles) == 0 {
+		return fmt.Errorf("at least one variant profile is required")
+	}
+
+	names := make(map[string]struct{}, len(profiles))
+	for i := range profiles {
+		p := &profiles[i]
+
+		if !profileNameRe.MatchString(p.Name) {
+			return fmt.Errorf("variant profile %d: invalid name %q", i, p.Name)
+		}
+		if models.ReservedVariants[p.Name] {
+			return fmt.Errorf("variant profile %q: the name is reserved", p.Name)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("variant profile %q: duplicate name", p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		if p.Format == "" {
+			p.Format = models.FormatJPEG
+		}
+		switch p.Format {
+		case models.FormatJPEG, models.FormatPNG, models.FormatGIF, models.FormatWebP, models.FormatSource:
+		default:
+			return fmt.Errorf("variant profile %q: unsupported format %q", p.Name, p.Format)
+		}
+
+		// The webp output is lossless, so it has no quality to set
+		if p.Format == models.FormatWebP && p.Quality != 0 {
+			return fmt.Errorf("variant profile %q: quality is not supported by the lossless webp format", p.Name)
+		}
+		if p.Quality == 0 {
+			p.Quality = jpeg.DefaultQuality
+		}
+		if p.Quality < 1 || p.Quality > 100 {
+			return fmt.Errorf("variant profile %q: quality must be between 1 and 100", p.Name)
+		}
+
+		if p.Fit == "" {
+			p.Fit = models.FitInside
+		}
+		switch p.Fit {
+		case models.FitStretch, models.FitInside, models.FitFill, models.FitPad:
+			if p.Width == 0 || p.Height == 0 {
+				return fmt.Errorf("variant profile %q: width and height are required", p.Name)
+			}
+		case models.FitWidth:
+			if p.Width == 0 {
+				return fmt.Errorf("variant profile %q: width is required", p.Name)
+			}
+		case models.FitHeight:
+			if p.Height == 0 {
+				return fmt.Errorf("variant profile %q: height is required", p.Name)
+			}
+		default:
+			return fmt.Errorf("variant profile %q: unsupported fit mode %q", p.Name, p.Fit)
+		}
+
+		if p.Background == "" {
+			p.Background = "#ffffff"
+		}
+		if _, err := p.BackgroundColor(); err != nil {
+			return fmt.Errorf("variant profile %q: %v", p.Name, err)
+		}
+	}
+
+	return nil
+}
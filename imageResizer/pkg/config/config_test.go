@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/demius1992/Image-service/imageResizer/internal/models"
+)
+
+func TestValidateProfilesQuality(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile models.VariantProfile
+		valid   bool
+	}{
+		{"jpeg with quality", models.VariantProfile{Name: "v", Width: 10, Height: 10, Format: models.FormatJPEG, Quality: 80}, true},
+		{"jpeg without quality", models.VariantProfile{Name: "v", Width: 10, Height: 10}, true},
+		{"webp without quality", models.VariantProfile{Name: "v", Width: 10, Height: 10, Format: models.FormatWebP}, true},
+		{"webp with quality", models.VariantProfile{Name: "v", Width: 10, Height: 10, Format: models.FormatWebP, Quality: 80}, false},
+		{"quality out of range", models.VariantProfile{Name: "v", Width: 10, Height: 10, Quality: 101}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateProfiles([]models.VariantProfile{tt.profile})
+			if tt.valid && err != nil {
+				t.Errorf("ValidateProfiles() = %v, want nil", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("ValidateProfiles() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestValidateProfilesReservedNames(t *testing.T) {
+	for _, name := range []string{"sanitized", "original", "variants", "status", "events"} {
+		profiles := []models.VariantProfile{{Name: name, Width: 10, Height: 10}}
+		if err := ValidateProfiles(profiles); err == nil {
+			t.Errorf("ValidateProfiles() accepted the reserved name %q", name)
+		}
+	}
+
+	profiles := []models.VariantProfile{{Name: "statuses", Width: 10, Height: 10}}
+	if err := ValidateProfiles(profiles); err != nil {
+		t.Errorf("ValidateProfiles() = %v, want nil", err)
+	}
+}
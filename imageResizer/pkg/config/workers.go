@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"github.com/demius1992/Image-service/imageResizer/internal/services"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// LoadPool loads the worker pool configuration from WORKER_COUNT, WORKER_MEMORY_BUDGET_MB and the
+// MAX_IMAGE_* limits. It defaults to a worker per CPU.
+func LoadPool() (services.PoolConfig, error) {
+	var err error
+	pool := services.PoolConfig{
+		Workers:       runtime.NumCPU(),
+		MemoryBudget:  512 << 20,
+		MaxWidth:      10000,
+		MaxHeight:     10000,
+		MaxMegapixels: 50,
+	}
+	if value := os.Getenv("WORKER_COUNT"); value != "" {
+		if pool.Workers, err = strconv.Atoi(value); err != nil {
+			return services.PoolConfig{}, fmt.Errorf("invalid WORKER_COUNT: %v", err)
+		}
+	}
+	if value := os.Getenv("WORKER_MEMORY_BUDGET_MB"); value != "" {
+		budget, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return services.PoolConfig{}, fmt.Errorf("invalid WORKER_MEMORY_BUDGET_MB: %v", err)
+		}
+		pool.MemoryBudget = budget << 20
+	}
+	if value := os.Getenv("MAX_IMAGE_WIDTH"); value != "" {
+		if pool.MaxWidth, err = strconv.Atoi(value); err != nil {
+			return services.PoolConfig{}, fmt.Errorf("invalid MAX_IMAGE_WIDTH: %v", err)
+		}
+	}
+	if value := os.Getenv("MAX_IMAGE_HEIGHT"); value != "" {
+		if pool.MaxHeight, err = strconv.Atoi(value); err != nil {
+			return services.PoolConfig{}, fmt.Errorf("invalid MAX_IMAGE_HEIGHT: %v", err)
+		}
+	}
+	if value := os.Getenv("MAX_IMAGE_MEGAPIXELS"); value != "" {
+		if pool.MaxMegapixels, err = strconv.ParseFloat(value, 64); err != nil {
+			return services.PoolConfig{}, fmt.Errorf("invalid MAX_IMAGE_MEGAPIXELS: %v", err)
+		}
+	}
+
+	return pool, nil
+}
+
+// LoadRetry loads the retry policy of the transient S3 and Kafka failures from the RETRY_*
+// settings.
+func LoadRetry() (services.RetryPolicy, error) {
+	var err error
+	retry := services.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+	if value := os.Getenv("RETRY_MAX_ATTEMPTS"); value != "" {
+		if retry.MaxAttempts, err = strconv.Atoi(value); err != nil {
+			return services.RetryPolicy{}, fmt.Errorf("invalid RETRY_MAX_ATTEMPTS: %v", err)
+		}
+	}
+	if value := os.Getenv("RETRY_INITIAL_BACKOFF"); value != "" {
+		if retry.InitialBackoff, err = time.ParseDuration(value); err != nil {
+			return services.RetryPolicy{}, fmt.Errorf("invalid RETRY_INITIAL_BACKOFF: %v", err)
+		}
+	}
+	if value := os.Getenv("RETRY_MAX_BACKOFF"); value != "" {
+		if retry.MaxBackoff, err = time.ParseDuration(value); err != nil {
+			return services.RetryPolicy{}, fmt.Errorf("invalid RETRY_MAX_BACKOFF: %v", err)
+		}
+	}
+
+	return retry, nil
+}
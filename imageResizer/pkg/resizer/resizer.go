@@ -0,0 +1,92 @@
+// Package resizer runs the resizer on a given object store and message bus, so that it can be
+// embedded in another process.
+package resizer
+
+import (
+	"context"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageResizer/internal/metadata"
+	"github.com/demius1992/Image-service/imageResizer/internal/repositories"
+	"github.com/demius1992/Image-service/imageResizer/internal/services"
+	"github.com/demius1992/Image-service/imageResizer/pkg/config"
+	"github.com/demius1992/Image-service/storage"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+// Topics names the topics of the resizer and its consumer group.
+type Topics struct {
+	// Input receives the resize requests
+	Input string
+	// Output receives the result and status events
+	Output string
+	// DeadLetter receives the requests that could not be processed
+	DeadLetter string
+	// GroupID defaults to image-resizer
+	GroupID string
+}
+
+// Resizer creates the variants of the images requested on the message bus.
+type Resizer struct {
+	kafkaService services.KafkaService
+	imageService *services.ImageService
+}
+
+// New creates a resizer storing the variants in the object store. The variant profiles, the
+// metadata whitelist, the key template, the worker pool and the retries are loaded from the
+// environment variables.
+func New(objectStore storage.Storage, messages bus.Bus, topics Topics) (*Resizer, error) {
+	// Load the variant profiles
+	profiles, err := config.LoadProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the metadata kept in the variants
+	metadataWhitelist, err := metadata.ParseWhitelist(os.Getenv("METADATA_WHITELIST"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the storage key layout of the variants
+	keyTemplate, err := config.LoadKeyTemplate()
+	if err != nil {
+		return nil, err
+	}
+	s3Repo := repositories.NewStorageRepository(objectStore, keyTemplate)
+
+	// Configure the worker pool and the retries of transient S3 and Kafka failures
+	pool, err := config.LoadPool()
+	if err != nil {
+		return nil, err
+	}
+	retry, err := config.LoadRetry()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new Kafka service on the message bus
+	groupID := topics.GroupID
+	if groupID == "" {
+		groupID = "image-resizer"
+	}
+	kafkaService, err := services.NewKafkaService(messages, topics.Input, topics.Output, topics.DeadLetter, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resizer{
+		kafkaService: kafkaService,
+		imageService: services.NewImageService(kafkaService, s3Repo, profiles, metadataWhitelist, pool, retry),
+	}, nil
+}
+
+// Run processes the requests until the context is done or the processing fails, then leaves the
+// consumer group. The message bus is left open for the caller to close.
+func (r *Resizer) Run(ctx context.Context) error {
+	err := r.imageService.ImageProcessor(ctx)
+	if closeErr := r.kafkaService.Close(); closeErr != nil {
+		logrus.Errorln(closeErr)
+	}
+	return err
+}
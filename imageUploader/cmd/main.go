@@ -6,7 +6,6 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"os"
-	"strings"
 )
 
 func main() {
@@ -15,16 +14,12 @@ func main() {
 		logrus.Fatalf("error loading env variables %s", err.Error())
 	}
 
-	app, err := server.NewApp(&config.Config{
-		AwsRegion:        os.Getenv("S3_REGION"),
-		AwsBucket:        os.Getenv("S3_BUCKET"),
-		KafkaBrokers:     strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
-		KafkaOutputTopic: os.Getenv("KAFKA_OUTPUT_TOPIC"),
-		KafkaInputTopic:  os.Getenv("KAFKA_INPUT_TOPIC"),
-		AccessKey:        os.Getenv("ACCESS_KEY"),
-		SecretKey:        os.Getenv("SECRET_KEY"),
-		Endpoint:         os.Getenv("ENDPOINT"),
-	})
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("%s", err.Error())
+	}
+
+	app, err := server.NewApp(cfg)
 	if err != nil {
 		logrus.Fatalf("%s", err.Error())
 	}
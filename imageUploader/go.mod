@@ -3,15 +3,19 @@ module github.com/demius1992/Image-service/imageUploader
 go 1.20
 
 require (
-	github.com/aws/aws-sdk-go v1.44.204
+	github.com/demius1992/Image-service/bus v0.0.0
+	github.com/demius1992/Image-service/storage v0.0.0
 	github.com/gin-gonic/gin v1.8.2
 	github.com/google/uuid v1.1.2
+	github.com/gorilla/websocket v1.5.0
 	github.com/joho/godotenv v1.5.1
-	github.com/segmentio/kafka-go v0.4.38
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/image v0.18.0
 )
 
 require (
+	github.com/aws/aws-sdk-go v1.44.204 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.14.0 // indirect
 	github.com/go-playground/universal-translator v0.18.0 // indirect
@@ -27,11 +31,17 @@ require (
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pelletier/go-toml/v2 v2.0.6 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/segmentio/kafka-go v0.4.38 // indirect
 	github.com/ugorji/go/codec v1.2.7 // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/net v0.4.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
-	golang.org/x/text v0.5.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+replace (
+	github.com/demius1992/Image-service/bus => ../bus
+	github.com/demius1992/Image-service/storage => ../storage
+)
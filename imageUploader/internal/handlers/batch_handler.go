@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// UploadBatch handles the batch upload endpoint. The body is either a multipart form with any
+// number of `images` files and `archive` zip or tar files, or a zip or tar archive itself. The
+// form fields are only taken into account when they precede the files; with an archive body the
+// options are taken from the query string.
+func (h *ImageHandle) UploadBatch(c *gin.Context) {
+	var (
+		opts   models.UploadOptions
+		source *batchSource
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		form, err := c.Request.MultipartReader()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if source, err = newFormSource(form, &opts); err != nil {
+			c.JSON(batchErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		for _, field := range []string{"sanitize", "deduplicate", "callback_url"} {
+			if value, ok := c.GetQuery(field); ok {
+				if err := setUploadOption(&opts, field, value); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		source = &batchSource{}
+		if err := source.openArchive(c.Request.Body); err != nil {
+			c.JSON(batchErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	defer source.close()
+
+	results, err := h.imageService.UploadBatch(source.next, opts)
+	response := batchResponse(results)
+	if err != nil {
+		response["error"] = err.Error()
+		c.JSON(batchErrorStatus(err), response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// batchErrorStatus returns the HTTP status of an error reading a batch.
+func batchErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, models.ErrTooLarge) || errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// batchResponse returns the response body listing the result of every file of a batch.
+func batchResponse(results []*models.BatchResult) gin.H {
+	items := make([]gin.H, 0, len(results))
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			status := uploadErrorStatus(result.Err)
+			if status == http.StatusInternalServerError {
+				logrus.Errorf("error ocured while uploading image %s of a batch: %v", result.Name, result.Err)
+			}
+			items = append(items, gin.H{"name": result.Name, "status": status, "error": result.Err.Error()})
+			continue
+		}
+
+		item := imageResponse(result.Image)
+		item["name"] = result.Name
+		item["status"] = http.StatusOK
+		items = append(items, item)
+	}
+
+	return gin.H{
+		"results":  items,
+		"uploaded": len(results) - failed,
+		"failed":   failed,
+	}
+}
+
+// batchSource yields the image files of a batch form and the entries of its archives.
+type batchSource struct {
+	form *multipart.Reader
+	// pending is the first file part, read while looking for the options
+	pending *multipart.Part
+	// entries yields the files of the archive being read, if any
+	entries      models.BatchSource
+	closeArchive func()
+}
+
+// newFormSource reads the options preceding the first file of the form.
+func newFormSource(form *multipart.Reader, opts *models.UploadOptions) (*batchSource, error) {
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: missing image files", models.ErrInvalidBatch)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "images" || part.FormName() == "archive" {
+			return &batchSource{form: form, pending: part}, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err = setUploadOption(opts, part.FormName(), string(value)); err != nil {
+			return nil, fmt.Errorf("%w: %v", models.ErrInvalidBatch, err)
+		}
+	}
+}
+
+// next returns the next image file of the batch.
+func (b *batchSource) next() (string, io.Reader, error) {
+	for {
+		if b.entries != nil {
+			name, file, err := b.entries()
+			if err != io.EOF {
+				return name, file, err
+			}
+			b.close()
+		}
+		if b.form == nil {
+			return "", nil, io.EOF
+		}
+
+		part := b.pending
+		b.pending = nil
+		if part == nil {
+			var err error
+			if part, err = b.form.NextPart(); err != nil {
+				return "", nil, err
+			}
+		}
+
+		// The fields following the first file are ignored
+		switch part.FormName() {
+		case "images":
+			return part.FileName(), part, nil
+		case "archive":
+			if err := b.openArchive(part); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+}
+
+// close releases the archive being read.
+func (b *batchSource) close() {
+	if b.closeArchive != nil {
+		b.closeArchive()
+	}
+	b.entries, b.closeArchive = nil, nil
+}
+
+// openArchive starts reading the entries of a zip, tar or gzipped tar archive, detected from its
+// magic bytes.
+func (b *batchSource) openArchive(r io.Reader) error {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(262)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return b.openZip(buffered)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		b.entries = tarEntries(tar.NewReader(gz))
+		b.closeArchive = func() { gz.Close() }
+		return nil
+	case len(magic) == 262 && string(magic[257:262]) == "ustar":
+		b.entries = tarEntries(tar.NewReader(buffered))
+		b.closeArchive = func() {}
+		return nil
+	}
+
+	return fmt.Errorf("%w: unsupported archive format, expected zip, tar or tar.gz", models.ErrInvalidBatch)
+}
+
+// openZip spools the zip archive to a temporary file, because its directory is at the end.
+func (b *batchSource) openZip(r io.Reader) error {
+	file, err := os.CreateTemp("", "image-batch-*.zip")
+	if err != nil {
+		return err
+	}
+	remove := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	size, err := io.Copy(file, r)
+	if err != nil {
+		remove()
+		return err
+	}
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		remove()
+		return fmt.Errorf("%w: %v", models.ErrInvalidBatch, err)
+	}
+
+	var (
+		i     int
+		entry io.ReadCloser
+	)
+	b.entries = func() (string, io.Reader, error) {
+		if entry != nil {
+			entry.Close()
+			entry = nil
+		}
+		for ; i < len(archive.File); i++ {
+			f := archive.File[i]
+			if f.FileInfo().IsDir() || skipEntry(f.Name) {
+				continue
+			}
+			i++
+			opened, err := f.Open()
+			if err != nil {
+				return "", nil, err
+			}
+			entry = opened
+			return f.Name, entry, nil
+		}
+		return "", nil, io.EOF
+	}
+	b.closeArchive = func() {
+		if entry != nil {
+			entry.Close()
+		}
+		remove()
+	}
+
+	return nil
+}
+
+// tarEntries yields the regular files of the tar archive.
+func tarEntries(archive *tar.Reader) models.BatchSource {
+	return func() (string, io.Reader, error) {
+		for {
+			header, err := archive.Next()
+			if err != nil {
+				return "", nil, err
+			}
+			if header.Typeflag == tar.TypeReg && !skipEntry(header.Name) {
+				return header.Name, archive, nil
+			}
+		}
+	}
+}
+
+// skipEntry reports whether the archive entry is metadata added by the archiver, such as the
+// macOS resource forks, rather than an image.
+func skipEntry(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// keepAliveInterval is how often an idle event stream is written to, so proxies keep it open.
+const keepAliveInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// The events hold only presigned URLs, which are safe to share with any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// StreamImageEvents handles the endpoint pushing the state changes of an image until it is
+// ready or failed. Clients get Server-Sent Events, or WebSocket messages when they ask for an upgrade.
+func (h *ImageHandle) StreamImageEvents(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	record, updates, stop, err := h.imageService.WatchImage(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+			return
+		}
+		logrus.Errorf("error occured while watching image: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the image status"})
+		return
+	}
+	defer stop()
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.streamWebSocket(c, record, updates)
+		return
+	}
+	h.streamSSE(c, record, updates)
+}
+
+// streamSSE writes the records as "status" Server-Sent Events.
+func (h *ImageHandle) streamSSE(c *gin.Context, record *models.ImageRecord, updates <-chan *models.ImageRecord) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.SSEvent("status", record)
+	c.Writer.Flush()
+
+	for !record.Done() {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-h.shutdown:
+			return
+		case <-keepAlive.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case record = <-updates:
+			c.SSEvent("status", record)
+		}
+		c.Writer.Flush()
+	}
+}
+
+// streamWebSocket writes the records as JSON WebSocket messages and closes the connection once
+// the image is done.
+func (h *ImageHandle) streamWebSocket(c *gin.Context, record *models.ImageRecord, updates <-chan *models.ImageRecord) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an error
+		logrus.Errorf("error occured while upgrading to websocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Control frames from the client are handled by the reader, which also notices the disconnect
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	if err = conn.WriteJSON(record); err != nil {
+		return
+	}
+
+	for !record.Done() {
+		select {
+		case <-closed:
+			return
+		case <-h.shutdown:
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			return
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		case record = <-updates:
+			err = conn.WriteJSON(record)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, record.Status), time.Now().Add(time.Second))
+}
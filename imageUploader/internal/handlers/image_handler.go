@@ -1,20 +1,30 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"github.com/demius1992/Image-service/imageUploader/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
 )
 
 // ImageServicer provides an interface for interacting with ImageService
 type ImageServicer interface {
-	UploadImage(image io.ReadSeeker) (*models.Image, error)
-	GetImage(id uuid.UUID) (*models.Image, error)
+	UploadImage(image io.Reader, opts models.UploadOptions) (*models.Image, error)
+	UploadImageFromURL(ctx context.Context, sourceURL string, opts models.UploadOptions) (*models.Image, error)
+	CreateUploadURL(contentType string, size int64) (*models.UploadURL, error)
+	CompleteUpload(id uuid.UUID, opts models.UploadOptions) (*models.Image, error)
+	UploadBatch(next models.BatchSource, opts models.UploadOptions) ([]*models.BatchResult, error)
+	GetImage(id uuid.UUID, variantName string) (*models.Image, error)
 	GetImageVariants(ids []string) ([]*models.Image, error)
+	ListImageVariants(id uuid.UUID) (*models.ImageRecord, error)
+	GetImageStatus(id uuid.UUID) (*models.ImageRecord, error)
+	WatchImage(id uuid.UUID) (*models.ImageRecord, <-chan *models.ImageRecord, func(), error)
 }
 
 type IDs struct {
@@ -26,76 +36,313 @@ type IDs struct {
 // ImageHandle handles the image-related endpoints.
 type ImageHandle struct {
 	imageService ImageServicer
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
 // NewImageHandler creates a new ImageHandle instance.
 func NewImageHandler(imageServicer ImageServicer) *ImageHandle {
 	return &ImageHandle{
 		imageService: imageServicer,
+		shutdown:     make(chan struct{}),
 	}
 }
 
-// UploadImage handles the image upload endpoint.
+// Shutdown ends the open event streams, so the server can stop.
+func (h *ImageHandle) Shutdown() {
+	h.shutdownOnce.Do(func() {
+		close(h.shutdown)
+	})
+}
+
+// maxFieldSize caps the size of the form fields read before the image.
+const maxFieldSize = 4 << 10
+
+// UploadImage handles the image upload endpoint. The multipart body is streamed, so the form
+// fields are only taken into account when they precede the image.
 func (h *ImageHandle) UploadImage(c *gin.Context) {
-	formFile, err := c.FormFile("image")
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
-		logrus.Errorf("error ocured while getting image from the request: %v", err)
+		logrus.Errorf("error ocured while reading the multipart request: %v", err)
 		c.JSON(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	src, err := formFile.Open()
+	var opts models.UploadOptions
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing image file"})
+			return
+		}
+		if err != nil {
+			logrus.Errorf("error ocured while getting image from the request: %v", err)
+			c.JSON(batchErrorStatus(err), err.Error())
+			return
+		}
+
+		if part.FormName() == "image" {
+			h.uploadImage(c, part, opts)
+			part.Close()
+			return
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+		part.Close()
+		if err != nil {
+			c.JSON(batchErrorStatus(err), err.Error())
+			return
+		}
+
+		if err = setUploadOption(&opts, part.FormName(), string(value)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+}
+
+// setUploadOption sets the upload option of the form field. Unknown fields are ignored.
+func setUploadOption(opts *models.UploadOptions, field, value string) error {
+	switch field {
+	case "sanitize":
+		sanitize, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid sanitize parameter")
+		}
+		opts.SanitizeOriginal = &sanitize
+	case "deduplicate":
+		deduplicate, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid deduplicate parameter")
+		}
+		opts.Deduplicate = &deduplicate
+	case "callback_url":
+		opts.CallbackURL = value
+	}
+	return nil
+}
+
+// uploadImage streams the image part to the image service.
+func (h *ImageHandle) uploadImage(c *gin.Context, src io.Reader, opts models.UploadOptions) {
+	// Upload the image to S3 and publish a message to Kafka
+	body := &readErrorReader{r: src}
+	image, err := h.imageService.UploadImage(body, opts)
 	if err != nil {
-		logrus.Errorf("error ocured while opening file from the request: %v", err)
-		c.JSON(http.StatusBadRequest, err.Error())
+		status := uploadErrorStatus(err)
+		// The request body over its limit fails the upload however the service reports it
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(body.err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		if status == http.StatusInternalServerError {
+			logrus.Errorf("error ocured while uploading image: %v", err)
+			c.JSON(status, err.Error())
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	defer src.Close()
-	body, err := io.ReadAll(src)
+	c.JSON(http.StatusOK, imageResponse(image))
+}
+
+// readErrorReader remembers the last read error of r other than io.EOF.
+type readErrorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *readErrorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+// uploadOptionsRequest holds the upload options of the JSON requests.
+type uploadOptionsRequest struct {
+	Sanitize    *bool  `json:"sanitize"`
+	Deduplicate *bool  `json:"deduplicate"`
+	CallbackURL string `json:"callback_url"`
+}
+
+func (r uploadOptionsRequest) options() models.UploadOptions {
+	return models.UploadOptions{
+		SanitizeOriginal: r.Sanitize,
+		Deduplicate:      r.Deduplicate,
+		CallbackURL:      r.CallbackURL,
+	}
+}
+
+type uploadFromURLRequest struct {
+	uploadOptionsRequest
+	URL string `json:"url" binding:"required"`
+}
+
+// UploadImageFromURL handles the endpoint uploading an image downloaded from a URL.
+func (h *ImageHandle) UploadImageFromURL(c *gin.Context) {
+	var req uploadFromURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse the image URL from the request body"})
+		return
+	}
+
+	image, err := h.imageService.UploadImageFromURL(c.Request.Context(), req.URL, req.options())
 	if err != nil {
-		logrus.Errorf("error ocured while io.ReadAll: %v", err)
-		c.JSON(http.StatusBadRequest, err.Error())
+		status := uploadErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			logrus.Errorf("error ocured while uploading image from %s: %v", req.URL, err)
+			c.JSON(status, err.Error())
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	reader := bytes.NewReader(body)
+	c.JSON(http.StatusOK, imageResponse(image))
+}
 
-	// Upload the image to S3 and publish a message to Kafka
-	image, err := h.imageService.UploadImage(reader)
+type uploadURLRequest struct {
+	ContentType string `json:"content_type" binding:"required"`
+	Size        int64  `json:"size" binding:"required,gt=0"`
+}
+
+// CreateUploadURL handles the endpoint returning a pre-signed URL uploading an image directly to
+// the storage.
+func (h *ImageHandle) CreateUploadURL(c *gin.Context) {
+	var req uploadURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse the content type and size from the request body"})
+		return
+	}
+
+	uploadURL, err := h.imageService.CreateUploadURL(req.ContentType, req.Size)
 	if err != nil {
-		logrus.Errorf("error ocured while uploading image: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
+		status := uploadErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			logrus.Errorf("error ocured while creating an upload URL: %v", err)
+			c.JSON(status, gin.H{"error": "Failed to create the upload URL"})
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":        image.ID,
-		"createdAt": image.CreatedAt,
-		"url":       image.URL,
-	})
+	c.JSON(http.StatusOK, uploadURL)
 }
 
-// GetImage handles the image retrieval endpoint.
-func (h *ImageHandle) GetImage(c *gin.Context) {
-	// Get the image ID from the request URL
-	id := c.Param("id")
+// CompleteUpload handles the endpoint processing an image uploaded with a pre-signed URL. The
+// upload options are given in an optional JSON body.
+func (h *ImageHandle) CompleteUpload(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	var req uploadOptionsRequest
+	if err = c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse the upload options from the request body"})
+		return
+	}
+
+	image, err := h.imageService.CompleteUpload(id, req.options())
+	if err != nil {
+		status := uploadErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			logrus.Errorf("error ocured while completing the upload of image %s: %v", id, err)
+			c.JSON(status, err.Error())
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, imageResponse(image))
+}
+
+// uploadErrorStatus returns the HTTP status of an upload error.
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrInvalidCallbackURL), errors.Is(err, models.ErrInvalidSourceURL):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, models.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, models.ErrFetchFailed):
+		return http.StatusBadGateway
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrUploadCompleted):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrUploadExpired):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
 
-	fromBytes, err := uuid.FromBytes([]byte(id))
+// imageResponse returns the response body of an uploaded image.
+func imageResponse(image *models.Image) gin.H {
+	response := gin.H{
+		"id":           image.ID,
+		"createdAt":    image.CreatedAt,
+		"url":          image.URL,
+		"size":         image.Size,
+		"checksums":    image.Checksums,
+		"deduplicated": image.Deduplicated,
+	}
+	if image.Deduplicated {
+		response["status"] = image.Status
+		response["variants"] = image.Variants
+	}
+
+	return response
+}
+
+// GetImage handles the retrieval endpoint of the original image and its variants.
+func (h *ImageHandle) GetImage(c *gin.Context) {
+	// Get the image ID and the variant name from the request URL
+	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		logrus.Println(err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
 		return
 	}
+
+	variantName := c.Param("variant")
+	if variantName == "" {
+		variantName = models.OriginalVariant
+	}
+
 	// Retrieve the image from S3
-	image, err := h.imageService.GetImage(fromBytes)
+	image, err := h.imageService.GetImage(id, variantName)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retrieve the image"})
+		switch {
+		case errors.Is(err, models.ErrInvalidVariant):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid variant parameter"})
+		case errors.Is(err, models.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		default:
+			logrus.Errorf("error occured while getting image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the image"})
+		}
 		return
 	}
 
-	// Return the image as the response
-	c.DataFromReader(http.StatusOK, image.Size, image.ContentType, bytes.NewReader(image.Content), make(map[string]string))
+	defer image.Body.Close()
+
+	headers := make(map[string]string)
+	if image.Checksums != nil {
+		headers["X-Checksum-Sha256"] = image.Checksums.SHA256
+		if image.Checksums.MD5 != "" {
+			headers["Content-MD5"] = image.Checksums.MD5
+		}
+	}
+
+	// Stream the image as the response
+	c.DataFromReader(http.StatusOK, image.Size, image.ContentType, image.Body, headers)
 }
 
 // GetImageVariants handles the image variant retrieval endpoint.
@@ -130,3 +377,56 @@ func (h *ImageHandle) GetImageVariants(c *gin.Context) {
 	// Return the image variants as the response
 	c.JSON(http.StatusOK, imageVariantResponses)
 }
+
+// ListImageVariants handles the endpoint returning all variants of an original image.
+func (h *ImageHandle) ListImageVariants(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	record, err := h.imageService.ListImageVariants(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+			return
+		}
+		logrus.Errorf("error occured while listing image variants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the image variants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       record.ID,
+		"status":   record.Status,
+		"variants": record.Variants,
+	})
+}
+
+// GetImageStatus handles the endpoint returning the processing state of an image.
+func (h *ImageHandle) GetImageStatus(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	record, err := h.imageService.GetImageStatus(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+			return
+		}
+		logrus.Errorf("error occured while getting image status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the image status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":        record.ID,
+		"status":    record.Status,
+		"reason":    record.FailureReason,
+		"updatedAt": record.UpdatedAt,
+	})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+)
+
+func TestUploadErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: the image exceeds 100 bytes", models.ErrTooLarge), http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("%w: 50000x50000 pixels", models.ErrTooLarge), http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("%w: text/html", models.ErrUnsupportedType), http.StatusUnsupportedMediaType},
+		{fmt.Errorf("%w: cannot read the image dimensions", models.ErrUnsupportedType), http.StatusUnsupportedMediaType},
+		{models.ErrInvalidCallbackURL, http.StatusBadRequest},
+		{models.ErrInvalidSourceURL, http.StatusBadRequest},
+		{models.ErrFetchFailed, http.StatusBadGateway},
+		{models.ErrNotFound, http.StatusNotFound},
+		{models.ErrUploadCompleted, http.StatusConflict},
+		{models.ErrUploadExpired, http.StatusGone},
+		{errors.New("failed to upload the original image to S3"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if status := uploadErrorStatus(tt.err); status != tt.status {
+			t.Errorf("uploadErrorStatus(%v) = %d, want %d", tt.err, status, tt.status)
+		}
+	}
+}
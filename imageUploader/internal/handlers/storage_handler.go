@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/demius1992/Image-service/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// StorageHandle serves the links signed by the storage drivers that cannot pre-sign URLs
+// themselves, such as the filesystem driver, in place of S3.
+type StorageHandle struct {
+	store  storage.Storage
+	signer *storage.Signer
+}
+
+// NewStorageHandler creates a new StorageHandle instance.
+func NewStorageHandler(store storage.Storage, signer *storage.Signer) *StorageHandle {
+	return &StorageHandle{
+		store:  store,
+		signer: signer,
+	}
+}
+
+// GetObject handles the signed download links.
+func (h *StorageHandle) GetObject(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if err := h.signer.Verify(http.MethodGet, key, c.Request.URL.Query(), "", -1); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	object, err := h.store.Get(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+			return
+		}
+		logrus.Errorf("error occured while getting object %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the object"})
+		return
+	}
+	defer object.Body.Close()
+
+	c.DataFromReader(http.StatusOK, object.Size, object.ContentType, object.Body, map[string]string{
+		"ETag": `"` + object.ETag + `"`,
+	})
+}
+
+// PutObject handles the signed upload links. The request must carry the content type and the
+// length the link was signed for.
+func (h *StorageHandle) PutObject(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if c.Request.ContentLength < 0 {
+		c.JSON(http.StatusLengthRequired, gin.H{"error": "the Content-Length header is required"})
+		return
+	}
+	contentType := c.GetHeader("Content-Type")
+	err := h.signer.Verify(http.MethodPut, key, c.Request.URL.Query(), contentType, c.Request.ContentLength)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = h.store.Put(key, c.Request.Body, storage.ObjectInfo{ContentType: contentType}); err != nil {
+		logrus.Errorf("error occured while storing object %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store the object"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// tusVersion is the version of the tus protocol implemented by the resumable uploads.
+const tusVersion = "1.0.0"
+
+// ResumableUploader provides an interface for interacting with ResumableUploadService
+type ResumableUploader interface {
+	MaxSize() int64
+	CreateUpload(length int64, metadata string) (*models.ResumableUpload, error)
+	GetUpload(id uuid.UUID) (*models.ResumableUpload, error)
+	WriteChunk(id uuid.UUID, offset int64, chunk io.Reader) (*models.ResumableUpload, error)
+	DeleteUpload(id uuid.UUID) error
+}
+
+// TusHandle handles the resumable upload endpoints of the tus protocol, with the creation and
+// termination extensions.
+type TusHandle struct {
+	uploads ResumableUploader
+}
+
+// NewTusHandler creates a new TusHandle instance.
+func NewTusHandler(uploader ResumableUploader) *TusHandle {
+	return &TusHandle{
+		uploads: uploader,
+	}
+}
+
+// Resumable checks the protocol version of the requests, except the OPTIONS ones which discover
+// it, and sets it on the responses.
+func (h *TusHandle) Resumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	c.Next()
+}
+
+// Options handles the discovery of the protocol version and extensions.
+func (h *TusHandle) Options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination")
+	if maxSize := h.uploads.MaxSize(); maxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload handles the creation of an upload. The image ID is the ID of the upload.
+func (h *TusHandle) CreateUpload(c *gin.Context) {
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deferred upload lengths are not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Length header"})
+		return
+	}
+
+	upload, err := h.uploads.CreateUpload(length, c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.Header("Location", "/uploads/"+upload.ID.String())
+	c.Status(http.StatusCreated)
+}
+
+// GetUpload handles the HEAD requests reporting the offset reached by an upload.
+func (h *TusHandle) GetUpload(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	upload, err := h.uploads.GetUpload(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		logrus.Errorf("error occured while getting upload %s: %v", id, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+// WriteChunk handles the PATCH requests appending a chunk to an upload. The image is processed
+// once the last chunk is received, and its errors reported like the direct uploads.
+func (h *TusHandle) WriteChunk(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "chunks must be sent as application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset header"})
+		return
+	}
+
+	upload, err := h.uploads.WriteChunk(id, offset, c.Request.Body)
+	if upload != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteUpload handles the termination of an upload.
+func (h *TusHandle) DeleteUpload(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return
+	}
+
+	if err = h.uploads.DeleteUpload(id); err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// respondUploadError maps the errors of the resumable uploads to HTTP statuses.
+func respondUploadError(c *gin.Context, err error) {
+	var status int
+	switch {
+	case errors.Is(err, models.ErrOffsetMismatch):
+		status = http.StatusConflict
+	case errors.Is(err, models.ErrUploadLocked):
+		status = http.StatusLocked
+	case errors.Is(err, models.ErrInvalidUploadMetadata):
+		status = http.StatusBadRequest
+	default:
+		status = uploadErrorStatus(err)
+	}
+
+	if status == http.StatusInternalServerError {
+		logrus.Errorf("error occured while handling a resumable upload: %v", err)
+		c.JSON(status, gin.H{"error": "Failed to process the upload"})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// WebhookServicer provides an interface for interacting with WebhookService
+type WebhookServicer interface {
+	RegisterWebhook(callbackURL string) (*models.Webhook, error)
+	ListWebhooks() ([]*models.Webhook, error)
+	DeleteWebhook(id uuid.UUID) error
+	ListDeliveries(status string) ([]*models.WebhookDelivery, error)
+	GetDelivery(id uuid.UUID) (*models.WebhookDelivery, error)
+	Redeliver(id uuid.UUID) (*models.WebhookDelivery, error)
+}
+
+type registerWebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// WebhookHandle handles the webhook endpoints.
+type WebhookHandle struct {
+	webhookService WebhookServicer
+}
+
+// NewWebhookHandler creates a new WebhookHandle instance.
+func NewWebhookHandler(webhookServicer WebhookServicer) *WebhookHandle {
+	return &WebhookHandle{
+		webhookService: webhookServicer,
+	}
+}
+
+// RegisterWebhook handles the webhook registration endpoint.
+func (h *WebhookHandle) RegisterWebhook(c *gin.Context) {
+	var req registerWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse the webhook URL from the request body"})
+		return
+	}
+
+	webhook, err := h.webhookService.RegisterWebhook(req.URL)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCallbackURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("error occured while registering webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register the webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// ListWebhooks handles the endpoint listing the registered webhooks.
+func (h *WebhookHandle) ListWebhooks(c *gin.Context) {
+	webhooks, err := h.webhookService.ListWebhooks()
+	if err != nil {
+		logrus.Errorf("error occured while listing webhooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list the webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// DeleteWebhook handles the webhook removal endpoint.
+func (h *WebhookHandle) DeleteWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	if err = h.webhookService.DeleteWebhook(id); err != nil {
+		respondDeliveryError(c, err, "Failed to delete the webhook")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries handles the endpoint listing the latest deliveries, filtered by the status query parameter.
+func (h *WebhookHandle) ListDeliveries(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status parameter"})
+		return
+	}
+
+	deliveries, err := h.webhookService.ListDeliveries(status)
+	if err != nil {
+		logrus.Errorf("error occured while listing webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list the deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// GetDelivery handles the endpoint returning a delivery with its attempts.
+func (h *WebhookHandle) GetDelivery(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	delivery, err := h.webhookService.GetDelivery(id)
+	if err != nil {
+		respondDeliveryError(c, err, "Failed to retrieve the delivery")
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// Redeliver handles the endpoint scheduling a new round of attempts of a delivery.
+func (h *WebhookHandle) Redeliver(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter"})
+		return
+	}
+
+	delivery, err := h.webhookService.Redeliver(id)
+	if err != nil {
+		respondDeliveryError(c, err, "Failed to redeliver")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// respondDeliveryError replies with 404 for unknown webhooks and deliveries and 500 otherwise.
+func respondDeliveryError(c *gin.Context, err error, message string) {
+	if errors.Is(err, models.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	logrus.Errorf("%s: %v", message, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
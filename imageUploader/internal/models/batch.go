@@ -0,0 +1,22 @@
+package models
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrInvalidBatch is returned when the files of a batch upload cannot be read.
+var ErrInvalidBatch = errors.New("invalid batch")
+
+// BatchSource yields the files of a batch upload in turn, and io.EOF after the last one. The
+// reader of a file is only valid until the next call.
+type BatchSource func() (name string, file io.Reader, err error)
+
+// BatchResult is the outcome of the upload of one file of a batch.
+type BatchResult struct {
+	// Name is the file name given in the form or the archive
+	Name string
+	// Image is the uploaded image, set when Err is nil
+	Image *Image
+	Err   error
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// EventVersion is the latest version of the resizer event schema understood by the uploader.
+const EventVersion = 1
+
+// Types of the events published by the resizer.
+const (
+	// EventProcessing is published when the resizer starts processing an image
+	EventProcessing = "image.processing"
+	// EventVariantsReady is published when all variants of an image are stored
+	EventVariantsReady = "variants.ready"
+	// EventFailed is published when the resizer gives up on an image
+	EventFailed = "image.failed"
+)
+
+// EventHeader holds the fields shared by all resizer events.
+type EventHeader struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	ImageID string `json:"image_id"`
+}
+
+// StatusEvent is published by the resizer on a processing state change of an image.
+type StatusEvent struct {
+	Version        int       `json:"version"`
+	Type           string    `json:"type"`
+	ImageID        string    `json:"image_id"`
+	Reason         string    `json:"reason,omitempty"`
+	ResizerVersion string    `json:"resizer_version"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// VariantsReadyEvent is published by the resizer once per processed image, keyed by the original image ID.
+type VariantsReadyEvent struct {
+	Version              int       `json:"version"`
+	Type                 string    `json:"type"`
+	ImageID              string    `json:"image_id"`
+	Variants             []Variant `json:"variants"`
+	ProcessingDurationMs int64     `json:"processing_duration_ms"`
+	ResizerVersion       string    `json:"resizer_version"`
+	ProcessedAt          time.Time `json:"processed_at"`
+}
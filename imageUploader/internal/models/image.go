@@ -1,15 +1,99 @@
 package models
 
 import (
+	"errors"
+	"io"
+	"regexp"
+	"time"
+
 	"github.com/google/uuid"
 )
 
+// OriginalVariant is the name of the uploaded image among its variants.
+const OriginalVariant = "original"
+
+// ErrInvalidVariant is returned for variant names that cannot name a resizer profile.
+var ErrInvalidVariant = errors.New("invalid variant name")
+
+// ErrUnsupportedType is returned for uploads whose content type is not accepted.
+var ErrUnsupportedType = errors.New("unsupported image type")
+
+// ErrTooLarge is returned for uploads over the size or dimension limits.
+var ErrTooLarge = errors.New("image too large")
+
+// ErrInvalidSourceURL is returned for image URLs that are not public HTTP or HTTPS URLs.
+var ErrInvalidSourceURL = errors.New("invalid image URL")
+
+// ErrFetchFailed is returned when a remote image cannot be downloaded.
+var ErrFetchFailed = errors.New("failed to fetch the image")
+
+// ErrUploadCompleted is returned when the direct upload of an image was already completed.
+var ErrUploadCompleted = errors.New("upload already completed")
+
+// ErrUploadExpired is returned when the direct upload of an image is completed past its deadline.
+var ErrUploadExpired = errors.New("upload expired")
+
+// variantNamePattern matches the profile names accepted by the resizer.
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidVariantName reports whether the name can name a variant.
+func ValidVariantName(name string) bool {
+	return variantNamePattern.MatchString(name)
+}
+
 type Image struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   string    `json:"created_at"`
-	Name        string    `json:"name"`
-	URL         string    `json:"url"`
-	ContentType string    `json:"content_type"`
-	Size        int64     `json:"size"`
-	Content     []byte    `json:"-"`
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   string     `json:"created_at"`
+	Name        string     `json:"name"`
+	URL         string     `json:"url"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Checksums   *Checksums `json:"checksums,omitempty"`
+	// Deduplicated is set when the upload matched an earlier image, which is returned instead
+	Deduplicated bool      `json:"deduplicated,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Variants     []Variant `json:"variants,omitempty"`
+	// Body streams the image content; it must be closed by the reader
+	Body io.ReadCloser `json:"-"`
+}
+
+// UploadOptions holds the per-upload processing options.
+type UploadOptions struct {
+	// SanitizeOriginal requests a copy of the original without metadata. The service default is used when nil.
+	SanitizeOriginal *bool
+	// Deduplicate returns an earlier image with the same content instead of storing the upload.
+	// The service default is used when nil.
+	Deduplicate *bool
+	// CallbackURL is notified by a webhook when the image is ready or failed
+	CallbackURL string
+}
+
+// Checksums of an uploaded image.
+type Checksums struct {
+	// SHA256 is the hex SHA-256 of the content
+	SHA256 string `json:"sha256"`
+	// MD5 is the base64 MD5 of the content, as sent in the Content-MD5 header
+	MD5 string `json:"md5"`
+}
+
+// UploadURL is a pre-signed URL uploading an original image directly to the storage.
+type UploadURL struct {
+	// ID is the ID reserved for the image
+	ID     uuid.UUID `json:"id"`
+	URL    string    `json:"url"`
+	Method string    `json:"method"`
+	// Headers must be sent with the upload, as they are part of the signature
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// UploadReservation is an image ID reserved for an upload with a pre-signed URL. The image is
+// staged under its own key until the upload is completed.
+type UploadReservation struct {
+	ID          uuid.UUID
+	ContentType string
+	Size        int64
+	// ExpiresAt is the deadline to complete the upload
+	ExpiresAt time.Time
+	CreatedAt time.Time
 }
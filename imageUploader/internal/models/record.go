@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned when a record does not exist in the metadata store.
+var ErrNotFound = errors.New("not found")
+
+// Processing states of an image.
+const (
+	// StatusUploaded is set once the original is stored
+	StatusUploaded = "uploaded"
+	// StatusQueued is set once the resize request is published to Kafka
+	StatusQueued = "queued"
+	// StatusProcessing is set when the resizer starts processing the image
+	StatusProcessing = "processing"
+	// StatusReady is set when all variants are stored
+	StatusReady = "ready"
+	// StatusFailed is set when the image could not be queued or was dead-lettered by the resizer
+	StatusFailed = "failed"
+)
+
+// statusRanks orders the states, so late or redelivered events never move an image back.
+var statusRanks = map[string]int{
+	StatusUploaded:   0,
+	StatusQueued:     1,
+	StatusProcessing: 2,
+	StatusReady:      3,
+	StatusFailed:     3,
+}
+
+// CanTransition reports whether an image may move from one state to the other.
+func CanTransition(from, to string) bool {
+	return statusRanks[to] > statusRanks[from]
+}
+
+// ImageRecord is the stored metadata of an uploaded image and its variants.
+type ImageRecord struct {
+	ID     uuid.UUID `json:"id"`
+	Status string    `json:"status"`
+	// FailureReason is the error of a failed image
+	FailureReason string `json:"failure_reason,omitempty"`
+	ContentType   string `json:"content_type"`
+	Size          int64  `json:"size"`
+	// Checksum is the SHA-256 of the content as "sha256:<hex>"
+	Checksum string    `json:"checksum,omitempty"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Variants []Variant `json:"variants"`
+	// CallbackURL is notified when the image is ready or failed
+	CallbackURL string    `json:"callback_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Done reports whether the image reached a final state.
+func (r *ImageRecord) Done() bool {
+	return r.Status == StatusReady || r.Status == StatusFailed
+}
+
+// Variant describes a stored image variant.
+type Variant struct {
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	URL         string `json:"url,omitempty"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Format      string `json:"format"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Checksum    string `json:"checksum"`
+}
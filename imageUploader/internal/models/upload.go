@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrOffsetMismatch is returned when a chunk does not start where the upload stopped.
+var ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+// ErrUploadLocked is returned when a chunk is sent while another one is being written.
+var ErrUploadLocked = errors.New("upload locked by another request")
+
+// ErrInvalidUploadMetadata is returned for Upload-Metadata headers that cannot be parsed.
+var ErrInvalidUploadMetadata = errors.New("invalid upload metadata")
+
+// ResumableUpload is an image uploaded in chunks with the tus protocol. The chunks are assembled
+// in an S3 multipart upload under the ID of the image.
+type ResumableUpload struct {
+	ID uuid.UUID
+	// MultipartID is the ID of the S3 multipart upload, cleared once the parts are assembled
+	MultipartID string
+	Length      int64
+	Offset      int64
+	// Parts is the number of parts uploaded to S3
+	Parts int
+	// Pending is the number of received bytes kept aside until they fill a part
+	Pending int64
+	// Metadata is the Upload-Metadata header given at creation
+	Metadata  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCallbackURL is returned for webhook URLs that are not absolute HTTP(S) URLs of public
+// addresses.
+var ErrInvalidCallbackURL = errors.New("invalid callback URL")
+
+// Types of the webhook events.
+const (
+	WebhookImageReady  = "image.ready"
+	WebhookImageFailed = "image.failed"
+)
+
+// States of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a callback URL notified about every image.
+type Webhook struct {
+	ID  uuid.UUID `json:"id"`
+	URL string    `json:"url"`
+	// Secret signs the payloads; it is only returned when the webhook is registered
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookPayload is the JSON body posted to the callback URLs.
+type WebhookPayload struct {
+	// ID identifies the event and stays the same when the delivery is retried
+	ID        uuid.UUID    `json:"id"`
+	Type      string       `json:"type"`
+	CreatedAt time.Time    `json:"created_at"`
+	Image     *ImageRecord `json:"image"`
+}
+
+// WebhookDelivery is the delivery of an event to one callback URL. Every redelivery starts a new
+// round of attempts.
+type WebhookDelivery struct {
+	ID uuid.UUID `json:"id"`
+	// WebhookID is nil for the callback URL given with the upload
+	WebhookID      *uuid.UUID       `json:"webhook_id,omitempty"`
+	ImageID        uuid.UUID        `json:"image_id"`
+	URL            string           `json:"url"`
+	EventType      string           `json:"event_type"`
+	Payload        []byte           `json:"-"`
+	Secret         string           `json:"-"`
+	Status         string           `json:"status"`
+	Round          int              `json:"round"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  time.Time        `json:"next_attempt_at"`
+	LastStatusCode int              `json:"last_status_code,omitempty"`
+	LastError      string           `json:"last_error,omitempty"`
+	AttemptLog     []WebhookAttempt `json:"attempt_log,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+// WebhookAttempt records a single delivery attempt.
+type WebhookAttempt struct {
+	Round       int       `json:"round"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
@@ -0,0 +1,321 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"time"
+
+	// Register the SQLite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteMigrations create and upgrade the tables of the metadata store. The number of applied
+// migrations is kept in the user_version of the database, so new ones must only be appended.
+var sqliteMigrations = []string{`
+CREATE TABLE IF NOT EXISTS images (
+	id           TEXT PRIMARY KEY,
+	status       TEXT NOT NULL,
+	content_type TEXT NOT NULL DEFAULT '',
+	size         INTEGER NOT NULL DEFAULT 0,
+	width        INTEGER NOT NULL DEFAULT 0,
+	height       INTEGER NOT NULL DEFAULT 0,
+	created_at   TIMESTAMP NOT NULL,
+	updated_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS variants (
+	image_id     TEXT NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+	name         TEXT NOT NULL,
+	key          TEXT NOT NULL,
+	width        INTEGER NOT NULL,
+	height       INTEGER NOT NULL,
+	format       TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	checksum     TEXT NOT NULL,
+	PRIMARY KEY (image_id, name)
+);
+`,
+	`ALTER TABLE images ADD COLUMN failure_reason TEXT NOT NULL DEFAULT ''`,
+	`
+ALTER TABLE images ADD COLUMN callback_url TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE webhooks (
+	id         TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	secret     TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	id               TEXT PRIMARY KEY,
+	webhook_id       TEXT REFERENCES webhooks (id) ON DELETE CASCADE,
+	image_id         TEXT NOT NULL,
+	url              TEXT NOT NULL,
+	event_type       TEXT NOT NULL,
+	payload          BLOB NOT NULL,
+	status           TEXT NOT NULL,
+	attempts         INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at  TIMESTAMP NOT NULL,
+	last_status_code INTEGER NOT NULL DEFAULT 0,
+	last_error       TEXT NOT NULL DEFAULT '',
+	created_at       TIMESTAMP NOT NULL,
+	updated_at       TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE webhook_attempts (
+	delivery_id  TEXT NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+	attempt      INTEGER NOT NULL,
+	status_code  INTEGER NOT NULL,
+	error        TEXT NOT NULL,
+	duration_ms  INTEGER NOT NULL,
+	attempted_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (delivery_id, attempt)
+);
+`,
+	`ALTER TABLE images ADD COLUMN checksum TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX images_checksum ON images (checksum)`,
+	`
+CREATE TABLE uploads (
+	id           TEXT PRIMARY KEY,
+	multipart_id TEXT NOT NULL,
+	length       INTEGER NOT NULL,
+	offset       INTEGER NOT NULL,
+	parts        INTEGER NOT NULL,
+	pending      INTEGER NOT NULL,
+	metadata     TEXT NOT NULL,
+	created_at   TIMESTAMP NOT NULL,
+	updated_at   TIMESTAMP NOT NULL
+);
+`,
+	`
+ALTER TABLE webhook_deliveries ADD COLUMN round INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE webhook_attempts_rounds (
+	delivery_id  TEXT NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+	round        INTEGER NOT NULL,
+	attempt      INTEGER NOT NULL,
+	status_code  INTEGER NOT NULL,
+	error        TEXT NOT NULL,
+	duration_ms  INTEGER NOT NULL,
+	attempted_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (delivery_id, round, attempt)
+);
+
+INSERT INTO webhook_attempts_rounds (delivery_id, round, attempt, status_code, error, duration_ms, attempted_at)
+SELECT delivery_id, 1, attempt, status_code, error, duration_ms, attempted_at FROM webhook_attempts;
+
+DROP TABLE webhook_attempts;
+
+ALTER TABLE webhook_attempts_rounds RENAME TO webhook_attempts;
+`,
+	`
+CREATE TABLE upload_reservations (
+	id           TEXT PRIMARY KEY,
+	content_type TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	expires_at   TIMESTAMP NOT NULL,
+	created_at   TIMESTAMP NOT NULL
+);
+`,
+}
+
+// SQLiteRepository stores the image metadata in an embedded SQLite database.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository opens the SQLite database at path and creates its tables.
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+
+	// SQLite allows a single writer, so the connections are serialized
+	db.SetMaxOpenConns(1)
+
+	if err = migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate SQLite schema: %v", err)
+	}
+
+	return &SQLiteRepository{db: db}, nil
+}
+
+// migrateSQLite applies the migrations the database has not seen yet.
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", version+1, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateImage stores a new image record.
+func (r *SQLiteRepository) CreateImage(ctx context.Context, record *models.ImageRecord) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO images (id, status, content_type, size, checksum, width, height, callback_url, created_at,
+		updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID.String(), record.Status, record.ContentType, record.Size, record.Checksum, record.Width,
+		record.Height, record.CallbackURL, record.CreatedAt, record.UpdatedAt)
+	return err
+}
+
+// GetImage returns the image record with its variants.
+func (r *SQLiteRepository) GetImage(ctx context.Context, id uuid.UUID) (*models.ImageRecord, error) {
+	record := &models.ImageRecord{ID: id}
+
+	err := r.db.QueryRowContext(ctx,
+		`SELECT status, failure_reason, content_type, size, checksum, width, height, callback_url, created_at,
+		updated_at FROM images WHERE id = ?`,
+		id.String()).
+		Scan(&record.Status, &record.FailureReason, &record.ContentType, &record.Size, &record.Checksum,
+			&record.Width, &record.Height, &record.CallbackURL, &record.CreatedAt, &record.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT name, key, width, height, format, content_type, size, checksum
+		FROM variants WHERE image_id = ? ORDER BY width * height, name`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	record.Variants = []models.Variant{}
+	for rows.Next() {
+		var v models.Variant
+		if err = rows.Scan(&v.Name, &v.Key, &v.Width, &v.Height, &v.Format, &v.ContentType, &v.Size,
+			&v.Checksum); err != nil {
+			return nil, err
+		}
+		record.Variants = append(record.Variants, v)
+	}
+
+	return record, rows.Err()
+}
+
+// FindImageByChecksum returns the oldest image with the content checksum that has not failed.
+func (r *SQLiteRepository) FindImageByChecksum(ctx context.Context, checksum string) (*models.ImageRecord, error) {
+	var id uuid.UUID
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id FROM images WHERE checksum = ? AND status != ? ORDER BY created_at LIMIT 1`,
+		checksum, models.StatusFailed).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetImage(ctx, id)
+}
+
+// SetVariants replaces the variants of the image and updates its status. It reports whether the
+// status changed, and returns models.ErrNotFound for an image unknown to the store.
+func (r *SQLiteRepository) SetVariants(ctx context.Context, id uuid.UUID, variants []models.Variant, status string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	current, err := imageStatus(ctx, tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		`UPDATE images SET status = ?, failure_reason = '', updated_at = ? WHERE id = ?`,
+		status, now, id.String()); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM variants WHERE image_id = ?`, id.String()); err != nil {
+		return false, err
+	}
+
+	for _, v := range variants {
+		if _, err = tx.ExecContext(ctx,
+			`INSERT INTO variants (image_id, name, key, width, height, format, content_type, size, checksum)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id.String(), v.Name, v.Key, v.Width, v.Height, v.Format, v.ContentType, v.Size, v.Checksum); err != nil {
+			return false, err
+		}
+	}
+
+	return current != status, tx.Commit()
+}
+
+// SetStatus moves the image to a new processing state and reports whether it moved. Transitions
+// to an earlier state are ignored, so events that arrive late or are redelivered never move an
+// image back. It returns models.ErrNotFound for an image unknown to the store.
+func (r *SQLiteRepository) SetStatus(ctx context.Context, id uuid.UUID, status, reason string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	current, err := imageStatus(ctx, tx, id)
+	if err != nil {
+		return false, err
+	}
+	if !models.CanTransition(current, status) {
+		return false, tx.Commit()
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		`UPDATE images SET status = ?, failure_reason = ?, updated_at = ? WHERE id = ?`,
+		status, reason, now, id.String()); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// imageStatus returns the status of the image, or models.ErrNotFound when it is not in the store.
+func imageStatus(ctx context.Context, tx *sql.Tx, id uuid.UUID) (string, error) {
+	var status string
+	err := tx.QueryRowContext(ctx, `SELECT status FROM images WHERE id = ?`, id.String()).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", models.ErrNotFound
+	}
+	return status, err
+}
+
+// Close closes the database.
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
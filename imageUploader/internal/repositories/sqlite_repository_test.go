@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestStatusOfUnknownImage(t *testing.T) {
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "images.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	ctx := context.Background()
+
+	id := uuid.New()
+	if _, err = repo.SetStatus(ctx, id, models.StatusFailed, "failed"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("SetStatus() = %v, want %v", err, models.ErrNotFound)
+	}
+	variants := []models.Variant{{Name: "small", Key: id.String() + "/small.jpg"}}
+	if _, err = repo.SetVariants(ctx, id, variants, models.StatusReady); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("SetVariants() = %v, want %v", err, models.ErrNotFound)
+	}
+
+	// No record is created for the unknown image
+	if _, err = repo.GetImage(ctx, id); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("GetImage() = %v, want %v", err, models.ErrNotFound)
+	}
+
+	// A known image still moves forward
+	now := time.Now().UTC()
+	if err = repo.CreateImage(ctx, &models.ImageRecord{ID: id, Status: models.StatusQueued, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := repo.SetVariants(ctx, id, variants, models.StatusReady)
+	if err != nil || !changed {
+		t.Fatalf("SetVariants() = %v, %v, want a changed status", changed, err)
+	}
+	record, err := repo.GetImage(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != models.StatusReady || len(record.Variants) != 1 {
+		t.Errorf("image %s with %d variants, want ready with 1", record.Status, len(record.Variants))
+	}
+}
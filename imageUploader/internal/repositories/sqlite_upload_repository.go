@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+)
+
+// CreateUpload stores a new resumable upload.
+func (r *SQLiteRepository) CreateUpload(ctx context.Context, upload *models.ResumableUpload) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO uploads (id, multipart_id, length, offset, parts, pending, metadata, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		upload.ID.String(), upload.MultipartID, upload.Length, upload.Offset, upload.Parts, upload.Pending,
+		upload.Metadata, upload.CreatedAt, upload.UpdatedAt)
+	return err
+}
+
+// GetUpload returns a resumable upload, or models.ErrNotFound when it does not exist.
+func (r *SQLiteRepository) GetUpload(ctx context.Context, id uuid.UUID) (*models.ResumableUpload, error) {
+	upload := &models.ResumableUpload{}
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, multipart_id, length, offset, parts, pending, metadata, created_at, updated_at
+		FROM uploads WHERE id = ?`, id.String()).
+		Scan(&upload.ID, &upload.MultipartID, &upload.Length, &upload.Offset, &upload.Parts, &upload.Pending,
+			&upload.Metadata, &upload.CreatedAt, &upload.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// UpdateUpload stores the progress of a resumable upload.
+func (r *SQLiteRepository) UpdateUpload(ctx context.Context, upload *models.ResumableUpload) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE uploads SET multipart_id = ?, offset = ?, parts = ?, pending = ?, updated_at = ? WHERE id = ?`,
+		upload.MultipartID, upload.Offset, upload.Parts, upload.Pending, upload.UpdatedAt, upload.ID.String())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// DeleteUpload removes a resumable upload.
+func (r *SQLiteRepository) DeleteUpload(ctx context.Context, id uuid.UUID) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM uploads WHERE id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// CreateReservation stores the reservation of an image ID for an upload with a pre-signed URL.
+func (r *SQLiteRepository) CreateReservation(ctx context.Context, reservation *models.UploadReservation) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO upload_reservations (id, content_type, size, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		reservation.ID.String(), reservation.ContentType, reservation.Size, reservation.ExpiresAt,
+		reservation.CreatedAt)
+	return err
+}
+
+// ClaimReservation removes the reservation of an image ID and returns it, so that it is claimed
+// once. It returns models.ErrNotFound when the ID is not reserved.
+func (r *SQLiteRepository) ClaimReservation(ctx context.Context, id uuid.UUID) (*models.UploadReservation, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	reservation := &models.UploadReservation{}
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, content_type, size, expires_at, created_at FROM upload_reservations WHERE id = ?`, id.String()).
+		Scan(&reservation.ID, &reservation.ContentType, &reservation.Size, &reservation.ExpiresAt,
+			&reservation.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM upload_reservations WHERE id = ?`, id.String()); err != nil {
+		return nil, err
+	}
+
+	return reservation, tx.Commit()
+}
@@ -0,0 +1,230 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+// deliveryColumns are the webhook_deliveries columns read by scanDelivery.
+const deliveryColumns = `d.id, d.webhook_id, d.image_id, d.url, d.event_type, d.payload, d.status, d.round,
+	d.attempts, d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.updated_at`
+
+// CreateWebhook registers a callback URL.
+func (r *SQLiteRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO webhooks (id, url, secret, created_at) VALUES (?, ?, ?, ?)`,
+		webhook.ID.String(), webhook.URL, webhook.Secret, webhook.CreatedAt)
+	return err
+}
+
+// ListWebhooks returns the registered callback URLs with their secrets.
+func (r *SQLiteRepository) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, url, secret, created_at FROM webhooks ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		webhook := &models.Webhook{}
+		if err = rows.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a callback URL with its deliveries.
+func (r *SQLiteRepository) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// CreateDeliveries stores the pending deliveries of an event.
+func (r *SQLiteRepository) CreateDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		var webhookID *string
+		if d.WebhookID != nil {
+			id := d.WebhookID.String()
+			webhookID = &id
+		}
+
+		if _, err = tx.ExecContext(ctx,
+			`INSERT INTO webhook_deliveries (id, webhook_id, image_id, url, event_type, payload, status, round,
+			attempts, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.ID.String(), webhookID, d.ImageID.String(), d.URL, d.EventType, d.Payload, d.Status, d.Round,
+			d.Attempts, d.NextAttemptAt, d.CreatedAt, d.UpdatedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DueDeliveries returns up to limit pending deliveries whose next attempt is due, with the secrets
+// of their webhooks.
+func (r *SQLiteRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+deliveryColumns+`, COALESCE(w.secret, '')
+		FROM webhook_deliveries d LEFT JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at LIMIT ?`,
+		models.DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		d := &models.WebhookDelivery{}
+		if err = scanDelivery(rows, d, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RecordAttempt stores an attempt of the delivery together with the delivery's new state. The
+// attempts are numbered within the round of the delivery, so the earlier rounds are kept.
+func (r *SQLiteRepository) RecordAttempt(ctx context.Context, d *models.WebhookDelivery, attempt models.WebhookAttempt) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx,
+		`INSERT INTO webhook_attempts (delivery_id, round, attempt, status_code, error, duration_ms, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.ID.String(), attempt.Round, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs,
+		attempt.AttemptedAt); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?,
+		last_error = ?, updated_at = ? WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, d.UpdatedAt,
+		d.ID.String()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListDeliveries returns the latest deliveries, optionally only those in the given state.
+func (r *SQLiteRepository) ListDeliveries(ctx context.Context, status string, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		WHERE ? = '' OR d.status = ?
+		ORDER BY d.created_at DESC LIMIT ?`,
+		status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d := &models.WebhookDelivery{}
+		if err = scanDelivery(rows, d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// GetDelivery returns a delivery with its attempts.
+func (r *SQLiteRepository) GetDelivery(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	err := scanDelivery(r.db.QueryRowContext(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries d WHERE d.id = ?`, id.String()), d)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT round, attempt, status_code, error, duration_ms, attempted_at
+		FROM webhook_attempts WHERE delivery_id = ? ORDER BY round, attempt`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.WebhookAttempt
+		if err = rows.Scan(&a.Round, &a.Attempt, &a.StatusCode, &a.Error, &a.DurationMs, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+
+	return d, rows.Err()
+}
+
+// ResetDelivery makes a delivery pending again with a new round of attempts starting now. The
+// attempts of the earlier rounds are kept.
+func (r *SQLiteRepository) ResetDelivery(ctx context.Context, id uuid.UUID, now time.Time) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, round = round + 1, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ?`,
+		models.DeliveryPending, now, now, id.String())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// scanDelivery reads the deliveryColumns of a row followed by the extra destinations.
+func scanDelivery(row interface{ Scan(...interface{}) error }, d *models.WebhookDelivery, extra ...interface{}) error {
+	var webhookID sql.NullString
+	dest := append([]interface{}{&d.ID, &webhookID, &d.ImageID, &d.URL, &d.EventType, &d.Payload, &d.Status,
+		&d.Round, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+
+	if webhookID.Valid {
+		id, err := uuid.Parse(webhookID.String)
+		if err != nil {
+			return err
+		}
+		d.WebhookID = &id
+	}
+	return nil
+}
+
+// expectAffected returns models.ErrNotFound when the statement changed no rows.
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
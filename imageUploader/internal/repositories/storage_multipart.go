@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"bytes"
+	"github.com/demius1992/Image-service/storage"
+	"github.com/google/uuid"
+	"io"
+)
+
+// pendingPartKey is the key of the bytes of a resumable upload waiting to fill a part.
+func pendingPartKey(id uuid.UUID) string {
+	return "uploads/" + id.String() + ".part"
+}
+
+// CreateMultipartUpload starts assembling the staged image of an upload in parts and returns the ID of the
+// multipart upload.
+func (r *StorageRepository) CreateMultipartUpload(id uuid.UUID) (string, error) {
+	return r.store.CreateMultipart(stagedUploadKey(id))
+}
+
+// UploadPart stores a part of the staged image. Every part but the last one must hold at least
+// 5 MB.
+func (r *StorageRepository) UploadPart(id uuid.UUID, multipartID string, number int, data []byte) error {
+	return r.store.UploadPart(stagedUploadKey(id), multipartID, number, data)
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the staged image, which is processed
+// like the images uploaded with a pre-signed URL.
+func (r *StorageRepository) CompleteMultipartUpload(id uuid.UUID, multipartID string) error {
+	return r.store.CompleteMultipart(stagedUploadKey(id), multipartID)
+}
+
+// AbortMultipartUpload discards the uploaded parts.
+func (r *StorageRepository) AbortMultipartUpload(id uuid.UUID, multipartID string) error {
+	return r.store.AbortMultipart(stagedUploadKey(id), multipartID)
+}
+
+// PutPendingPart keeps aside the bytes received for a part that is not full yet.
+func (r *StorageRepository) PutPendingPart(id uuid.UUID, data []byte) error {
+	return r.store.Put(pendingPartKey(id), bytes.NewReader(data), storage.ObjectInfo{})
+}
+
+// GetPendingPart reads the bytes kept aside by PutPendingPart into buf, which must have their size.
+func (r *StorageRepository) GetPendingPart(id uuid.UUID, buf []byte) error {
+	object, err := r.store.Get(pendingPartKey(id))
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	_, err = io.ReadFull(object.Body, buf)
+	return err
+}
+
+// DeletePendingPart removes the bytes kept aside by PutPendingPart.
+func (r *StorageRepository) DeletePendingPart(id uuid.UUID) error {
+	return r.store.Delete(pendingPartKey(id))
+}
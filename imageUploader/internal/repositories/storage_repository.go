@@ -0,0 +1,241 @@
+package repositories
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/demius1992/Image-service/storage"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StorageRepository provides methods for storing the images with the configured storage driver.
+type StorageRepository struct {
+	store       storage.Storage
+	keyTemplate string
+}
+
+// NewStorageRepository creates a new StorageRepository instance. The key template is the storage
+// key layout of the variants written by the resizer.
+func NewStorageRepository(store storage.Storage, keyTemplate string) *StorageRepository {
+	return &StorageRepository{
+		store:       store,
+		keyTemplate: keyTemplate,
+	}
+}
+
+// UploadImage streams a file to the storage.
+func (r *StorageRepository) UploadImage(id uuid.UUID, data io.Reader, contentType string) (string, error) {
+	err := r.store.Put(id.String(), data, storage.ObjectInfo{ContentType: contentType})
+	if err != nil {
+		logrus.Errorf("error occured while uploading file to storage: %s", err.Error())
+		return "", err
+	}
+
+	// Generate a pre-signed URL for the uploaded file
+	url, err := r.store.PresignGet(id.String(), time.Hour)
+	if err != nil {
+		return "", err
+	}
+
+	return url, nil
+}
+
+// StoreChecksums verifies the stored image against its MD5 and keeps the checksums as object
+// metadata. The S3 requests of the upload already carry the Content-MD5 of their body, so S3
+// rejects corrupted parts; a single-part object is additionally compared as a whole through its
+// ETag.
+func (r *StorageRepository) StoreChecksums(id uuid.UUID, contentType string, checksums models.Checksums) error {
+	head, err := r.store.Head(id.String())
+	if err != nil {
+		return err
+	}
+
+	// The ETag of a multipart object is not the MD5 of its content and contains a dash
+	if !strings.Contains(head.ETag, "-") {
+		sum, err := base64.StdEncoding.DecodeString(checksums.MD5)
+		if err != nil {
+			return err
+		}
+		if head.ETag != hex.EncodeToString(sum) {
+			return fmt.Errorf("checksum mismatch: stored ETag %s, uploaded MD5 %x", head.ETag, sum)
+		}
+	}
+
+	return r.store.UpdateInfo(id.String(), storage.ObjectInfo{
+		ContentType: contentType,
+		Metadata: map[string]string{
+			"Sha256":      checksums.SHA256,
+			"Content-Md5": checksums.MD5,
+		},
+	})
+}
+
+// DeleteImage removes an uploaded image.
+func (r *StorageRepository) DeleteImage(id uuid.UUID) error {
+	return r.store.Delete(id.String())
+}
+
+// GetImage retrieves the original image or one of its variants from the storage. The returned
+// image streams the object body, which the caller must close. It returns models.ErrNotFound when
+// the image or the variant does not exist.
+func (r *StorageRepository) GetImage(id uuid.UUID, variantName string) (*models.Image, error) {
+	key := id.String()
+	if variantName != models.OriginalVariant {
+		var err error
+		if key, err = r.FindVariantKey(id, variantName); err != nil {
+			return nil, err
+		}
+	}
+
+	// Retrieve the variant from the storage
+	object, err := r.store.Get(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: image %s variant %s", models.ErrNotFound, id, variantName)
+		}
+		logrus.Errorf("error ocured while getting file from storage: %v", err)
+		return nil, err
+	}
+
+	url, err := r.store.PresignGet(key, time.Hour)
+	if err != nil {
+		object.Body.Close()
+		logrus.Errorf("error occured while signing image URL: %s", err.Error())
+		return nil, err
+	}
+
+	body := object.Body
+	contentType := object.ContentType
+	if !knownContentType(contentType) {
+		// Images stored without a content type are sniffed from a peeked prefix
+		reader := bufio.NewReaderSize(object.Body, 512)
+		prefix, _ := reader.Peek(512)
+		contentType = http.DetectContentType(prefix)
+		body = struct {
+			io.Reader
+			io.Closer
+		}{reader, object.Body}
+	}
+
+	// Create and return the image variant model
+	image := &models.Image{
+		ID:          id,
+		Name:        variantName,
+		URL:         url, // use the Object URL as the variant URL
+		ContentType: contentType,
+		Size:        object.Size,
+		Body:        body,
+	}
+	if sha := object.Metadata["Sha256"]; sha != "" {
+		image.Checksums = &models.Checksums{SHA256: sha, MD5: object.Metadata["Content-Md5"]}
+	}
+	return image, nil
+}
+
+// GetImageVariants returns the presigned URLs of the objects stored under the keys.
+func (r *StorageRepository) GetImageVariants(ids []string) ([]*models.Image, error) {
+	// Create a slice to hold the variants
+	var variants []*models.Image
+
+	for _, id := range ids {
+
+		// Check the variant exists without downloading it
+		info, err := r.store.Head(id)
+		if err != nil {
+			logrus.Errorf("error occured while getting file from storage: %v", err)
+			return nil, err
+		}
+
+		url, err := r.store.PresignGet(id, time.Hour)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create and return the image variant model
+		image := &models.Image{
+			URL:         url, // use the Object URL as the variant URL
+			ContentType: info.ContentType,
+			Size:        info.Size,
+		}
+		variants = append(variants, image)
+	}
+
+	return variants, nil
+}
+
+// knownContentType reports whether the stored content type describes the object.
+func knownContentType(contentType string) bool {
+	return contentType != "" && contentType != "binary/octet-stream" && contentType != "application/octet-stream"
+}
+
+// FindVariantKey locates the storage key of a variant from the image ID and variant name alone.
+// The key template is expanded up to the {ext} placeholder, since the extension depends on the
+// output format chosen by the resizer, and the first key with that prefix is returned.
+func (r *StorageRepository) FindVariantKey(id uuid.UUID, variantName string) (string, error) {
+	template := r.keyTemplate
+	hasExt := strings.Contains(template, "{ext}")
+	if hasExt {
+		template = template[:strings.Index(template, "{ext}")]
+	}
+
+	prefix := strings.NewReplacer("{id}", id.String(), "{variant}", variantName).Replace(template)
+	if !hasExt {
+		return prefix, nil
+	}
+
+	keys, err := r.store.List(prefix, 1)
+	if err != nil {
+		logrus.Errorf("error occured while listing variants: %v", err)
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("%w: variant %s of image %s", models.ErrNotFound, variantName, id)
+	}
+
+	return keys[0], nil
+}
+
+// PresignURL returns a pre-signed download URL for the object stored under the key.
+func (r *StorageRepository) PresignURL(key string) (string, error) {
+	return r.store.PresignGet(key, time.Hour)
+}
+
+// stagedUploadKey is the key of an image uploaded with a pre-signed URL until it is validated.
+func stagedUploadKey(id uuid.UUID) string {
+	return "uploads/" + id.String()
+}
+
+// PresignUpload returns a pre-signed URL uploading an image to its staging key with a PUT request,
+// and the headers the request must carry. The content type and the size are part of the signature.
+// The staged image is only copied to the key of the original once validated, so the URL can never
+// replace an original.
+func (r *StorageRepository) PresignUpload(id uuid.UUID, contentType string, size int64,
+	duration time.Duration) (string, http.Header, error) {
+	return r.store.PresignPut(stagedUploadKey(id), contentType, size, duration)
+}
+
+// GetStagedUpload streams an image uploaded with a pre-signed URL. It returns models.ErrNotFound
+// when nothing was uploaded.
+func (r *StorageRepository) GetStagedUpload(id uuid.UUID) (io.ReadCloser, error) {
+	object, err := r.store.Get(stagedUploadKey(id))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: no image uploaded for %s", models.ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+// DeleteStagedUpload removes an image uploaded with a pre-signed URL.
+func (r *StorageRepository) DeleteStagedUpload(id uuid.UUID) error {
+	return r.store.Delete(stagedUploadKey(id))
+}
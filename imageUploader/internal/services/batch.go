@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+)
+
+// BatchConfig bounds the batch uploads.
+type BatchConfig struct {
+	// Concurrency is the number of files of a batch uploaded at the same time
+	Concurrency int
+	// MaxFiles is the number of files accepted in a batch. Zero disables the limit.
+	MaxFiles int
+}
+
+// UploadBatch uploads the files of the batch with the same options. The files are read in turn and
+// spooled to temporary files, so that up to the configured number of them are uploaded while the
+// next ones are read. Every file gets its own result, in the order of the batch; an error is only
+// returned, along with the results so far, when the batch itself cannot be read or is too large.
+func (s *ImageService) UploadBatch(next models.BatchSource, opts models.UploadOptions) ([]*models.BatchResult, error) {
+	var (
+		results []*models.BatchResult
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, s.batch.Concurrency)
+	)
+
+	for {
+		name, src, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			wg.Wait()
+			return results, fmt.Errorf("%w: %w", models.ErrInvalidBatch, err)
+		}
+		if s.batch.MaxFiles > 0 && len(results) == s.batch.MaxFiles {
+			wg.Wait()
+			return results, fmt.Errorf("%w: the batch exceeds %d files", models.ErrTooLarge, s.batch.MaxFiles)
+		}
+
+		result := &models.BatchResult{Name: name}
+		results = append(results, result)
+
+		file, err := s.spool(src)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer removeSpool(file)
+
+			result.Image, result.Err = s.UploadImage(file, opts)
+		}()
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// spool copies the file of a batch to a temporary file, which is rewound for reading.
+func (s *ImageService) spool(src io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "image-batch-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a temporary file: %v", err)
+	}
+
+	_, err = io.Copy(file, &limitedReader{r: src, max: s.limits.MaxBytes})
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeSpool(file)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// removeSpool closes and deletes a temporary file created by spool.
+func removeSpool(file *os.File) {
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		logrus.Errorf("failed to remove the temporary file %s: %v", file.Name(), err)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	// uploadURLExpiry is the validity of the pre-signed upload URLs.
+	uploadURLExpiry = 15 * time.Minute
+	// uploadCompleteWindow is the time left to complete an upload once its URL expired.
+	uploadCompleteWindow = time.Hour
+)
+
+// CreateUploadURL reserves an image ID and returns a pre-signed URL uploading the original image
+// of the given type and size directly to S3. The image is staged under its own key until
+// CompleteUpload is called.
+func (s *ImageService) CreateUploadURL(contentType string, size int64) (*models.UploadURL, error) {
+	if err := s.limits.checkType(contentType); err != nil {
+		return nil, err
+	}
+	if s.limits.MaxBytes > 0 && size > s.limits.MaxBytes {
+		return nil, fmt.Errorf("%w: the image exceeds %d bytes", models.ErrTooLarge, s.limits.MaxBytes)
+	}
+
+	id := uuid.New()
+	now := time.Now().UTC()
+	expiresAt := now.Add(uploadURLExpiry)
+	url, signedHeaders, err := s.s3Repo.PresignUpload(id, contentType, size, uploadURLExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign the upload URL: %v", err)
+	}
+
+	err = s.store.CreateReservation(context.Background(), &models.UploadReservation{
+		ID:          id,
+		ContentType: contentType,
+		Size:        size,
+		ExpiresAt:   expiresAt.Add(uploadCompleteWindow),
+		CreatedAt:   now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve the image ID: %v", err)
+	}
+
+	headers := make(map[string]string, len(signedHeaders))
+	for name := range signedHeaders {
+		headers[http.CanonicalHeaderKey(name)] = signedHeaders.Get(name)
+	}
+
+	return &models.UploadURL{
+		ID:        id,
+		URL:       url,
+		Method:    http.MethodPut,
+		Headers:   headers,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// CompleteUpload processes an image uploaded with a pre-signed URL once the reservation of its ID is
+// claimed. A completion failing for another reason than a refused image can be retried.
+func (s *ImageService) CompleteUpload(id uuid.UUID, opts models.UploadOptions) (*models.Image, error) {
+	if opts.CallbackURL != "" {
+		if err := s.webhooks.ValidateCallbackURL(opts.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkNotCompleted(id); err != nil {
+		return nil, err
+	}
+
+	// Claim the reservation, so that the upload is only completed once
+	reservation, err := s.store.ClaimReservation(context.Background(), id)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, fmt.Errorf("%w: no upload reserved for image %s", models.ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim the upload reservation: %v", err)
+	}
+	if time.Now().After(reservation.ExpiresAt) {
+		s.deleteStagedUpload(id)
+		return nil, fmt.Errorf("%w: image %s", models.ErrUploadExpired, id)
+	}
+
+	image, err := s.processStagedUpload(id, opts)
+	if err != nil && !refusedUpload(err) {
+		s.releaseReservation(reservation)
+	}
+
+	return image, err
+}
+
+// checkNotCompleted returns models.ErrUploadCompleted when the image is already recorded.
+func (s *ImageService) checkNotCompleted(id uuid.UUID) error {
+	_, err := s.store.GetImage(context.Background(), id)
+	if err == nil {
+		return fmt.Errorf("%w: image %s", models.ErrUploadCompleted, id)
+	}
+	if !errors.Is(err, models.ErrNotFound) {
+		return fmt.Errorf("failed to get the image record: %v", err)
+	}
+	return nil
+}
+
+// processStagedUpload validates the staged image of a direct or resumable upload while it is
+// copied to the key of the original, then records it and requests its variants. The staged image
+// is deleted once processed or refused.
+func (s *ImageService) processStagedUpload(id uuid.UUID, opts models.UploadOptions) (*models.Image, error) {
+	staged, err := s.s3Repo.GetStagedUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer staged.Close()
+
+	upload, err := s.validateUpload(staged)
+	var image *models.Image
+	if err == nil {
+		image, err = s.storeUpload(id, upload, opts)
+	}
+	if err == nil || refusedUpload(err) {
+		s.deleteStagedUpload(id)
+	}
+
+	return image, err
+}
+
+// refusedUpload reports whether the upload failed because the image was refused.
+func refusedUpload(err error) bool {
+	return errors.Is(err, models.ErrUnsupportedType) || errors.Is(err, models.ErrTooLarge)
+}
+
+// releaseReservation restores a claimed reservation, so that a failed completion can be retried,
+// unless the image was recorded before the failure.
+func (s *ImageService) releaseReservation(reservation *models.UploadReservation) {
+	if _, err := s.store.GetImage(context.Background(), reservation.ID); err == nil {
+		s.deleteStagedUpload(reservation.ID)
+		return
+	}
+	if err := s.store.CreateReservation(context.Background(), reservation); err != nil {
+		logrus.Errorf("error occured while restoring the upload reservation of image %s: %v", reservation.ID, err)
+	}
+}
+
+// deleteStagedUpload removes the staged image of an upload.
+func (s *ImageService) deleteStagedUpload(id uuid.UUID) {
+	if err := s.s3Repo.DeleteStagedUpload(id); err != nil {
+		logrus.Errorf("error occured while deleting the staged upload of image %s: %v", id, err)
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/demius1992/Image-service/bus"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// storeRetryInterval is the delay between attempts to read or apply an event when the message bus
+// or the metadata store fails.
+const storeRetryInterval = time.Second
+
+// ProcessEvents consumes the resizer events and records the produced variants in the metadata
+// store until the context is cancelled or the message bus is closed. An event's offset is committed
+// only after it was applied.
+func (s *ImageService) ProcessEvents(ctx context.Context) {
+	for {
+		msg, err := s.kafkaSrv.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, bus.ErrClosed) {
+				return
+			}
+			logrus.Errorf("error occured while reading an event from the message bus: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(storeRetryInterval):
+			}
+			continue
+		}
+
+		var id uuid.UUID
+		var status string
+		for {
+			id, status, err = s.applyEvent(ctx, msg)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			logrus.Errorf("error occured while applying the event of image %s: %v", msg.Key, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(storeRetryInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if id != uuid.Nil {
+			s.notify(ctx, id)
+		}
+		if status == models.StatusReady || status == models.StatusFailed {
+			s.enqueueWebhooks(ctx, id, status)
+		}
+
+		if err = s.kafkaSrv.CommitMessages(ctx, msg); err != nil {
+			logrus.Errorf("error occured while committing the event of image %s: %v", msg.Key, err)
+		}
+	}
+}
+
+// applyEvent updates the image record from a resizer event and returns the ID of the updated
+// image, with its new status when the status changed. Malformed and unknown events, and the events
+// of images unknown to the store, are logged and skipped, only metadata store failures are returned.
+func (s *ImageService) applyEvent(ctx context.Context, msg *bus.Message) (uuid.UUID, string, error) {
+	var header models.EventHeader
+	if err := json.Unmarshal(msg.Value, &header); err != nil {
+		logrus.Warnf("skipping malformed event at offset %d: %v", msg.Offset, err)
+		return uuid.Nil, "", nil
+	}
+	if header.Version > models.EventVersion {
+		logrus.Warnf("skipping unsupported event %q version %d", header.Type, header.Version)
+		return uuid.Nil, "", nil
+	}
+
+	id, err := uuid.Parse(header.ImageID)
+	if err != nil {
+		logrus.Warnf("skipping event with invalid image ID %q: %v", header.ImageID, err)
+		return uuid.Nil, "", nil
+	}
+
+	switch header.Type {
+	case models.EventVariantsReady:
+		var event models.VariantsReadyEvent
+		if err = json.Unmarshal(msg.Value, &event); err != nil {
+			logrus.Warnf("skipping malformed %s event of image %s: %v", header.Type, id, err)
+			return uuid.Nil, "", nil
+		}
+		changed, err := s.store.SetVariants(ctx, id, event.Variants, models.StatusReady)
+		if errors.Is(err, models.ErrNotFound) {
+			logrus.Warnf("skipping %s event of unknown image %s", header.Type, id)
+			return uuid.Nil, "", nil
+		}
+		return id, changedStatus(changed, models.StatusReady), err
+	case models.EventProcessing, models.EventFailed:
+		var event models.StatusEvent
+		if err = json.Unmarshal(msg.Value, &event); err != nil {
+			logrus.Warnf("skipping malformed %s event of image %s: %v", header.Type, id, err)
+			return uuid.Nil, "", nil
+		}
+		status := models.StatusProcessing
+		if header.Type == models.EventFailed {
+			status = models.StatusFailed
+		}
+		changed, err := s.store.SetStatus(ctx, id, status, event.Reason)
+		if errors.Is(err, models.ErrNotFound) {
+			logrus.Warnf("skipping %s event of unknown image %s", header.Type, id)
+			return uuid.Nil, "", nil
+		}
+		return id, changedStatus(changed, status), err
+	default:
+		logrus.Warnf("skipping unsupported event %q version %d", header.Type, header.Version)
+		return uuid.Nil, "", nil
+	}
+}
+
+// changedStatus returns the status if it changed and an empty string otherwise.
+func changedStatus(changed bool, status string) string {
+	if !changed {
+		return ""
+	}
+	return status
+}
+
+// enqueueWebhooks schedules the webhook deliveries of an image that reached a final state.
+func (s *ImageService) enqueueWebhooks(ctx context.Context, id uuid.UUID, status string) {
+	record, err := s.store.GetImage(ctx, id)
+	if err != nil {
+		logrus.Errorf("error occured while loading image %s for its webhooks: %v", id, err)
+		return
+	}
+
+	eventType := models.WebhookImageReady
+	if status == models.StatusFailed {
+		eventType = models.WebhookImageFailed
+	}
+	if err = s.webhooks.Enqueue(ctx, eventType, record); err != nil {
+		logrus.Errorf("error occured while scheduling the webhooks of image %s: %v", id, err)
+	}
+}
@@ -1,88 +1,367 @@
 package services
 
 import (
-	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/demius1992/Image-service/bus"
 	"github.com/demius1992/Image-service/imageUploader/internal/models"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"hash"
 	"io"
 	"net/http"
 	"time"
+
+	// Register the decoders used to read the image dimensions
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 )
 
 // KafkaService provides an interface for interacting with Kafka.
 type KafkaService interface {
-	SendMessage(ctx context.Context, id uuid.UUID) error
-	GetMessages() ([]string, error)
+	SendMessage(ctx context.Context, id uuid.UUID, sanitizeOriginal bool) error
+	FetchMessage(ctx context.Context) (*bus.Message, error)
+	CommitMessages(ctx context.Context, msgs ...*bus.Message) error
+	Close() error
 }
 
 // S3ImageRepository provides an interface for interacting with s3Repository
 type S3ImageRepository interface {
-	UploadImage(id uuid.UUID, data io.ReadSeeker) (string, error)
+	UploadImage(id uuid.UUID, data io.Reader, contentType string) (string, error)
+	StoreChecksums(id uuid.UUID, contentType string, checksums models.Checksums) error
+	PresignUpload(id uuid.UUID, contentType string, size int64, duration time.Duration) (string, http.Header, error)
+	GetStagedUpload(id uuid.UUID) (io.ReadCloser, error)
+	DeleteStagedUpload(id uuid.UUID) error
+	DeleteImage(id uuid.UUID) error
 	GetImage(id uuid.UUID, variantName string) (*models.Image, error)
 	GetImageVariants(ids []string) ([]*models.Image, error)
+	PresignURL(key string) (string, error)
+}
+
+// MetadataStore provides an interface for persisting the image records.
+type MetadataStore interface {
+	CreateImage(ctx context.Context, record *models.ImageRecord) error
+	GetImage(ctx context.Context, id uuid.UUID) (*models.ImageRecord, error)
+	FindImageByChecksum(ctx context.Context, checksum string) (*models.ImageRecord, error)
+	SetVariants(ctx context.Context, id uuid.UUID, variants []models.Variant, status string) (bool, error)
+	SetStatus(ctx context.Context, id uuid.UUID, status, reason string) (bool, error)
+	CreateReservation(ctx context.Context, reservation *models.UploadReservation) error
+	ClaimReservation(ctx context.Context, id uuid.UUID) (*models.UploadReservation, error)
+	Close() error
 }
 
 // ImageService handles the image-related operations.
 type ImageService struct {
-	s3Repo   S3ImageRepository
-	kafkaSrv KafkaService
+	s3Repo            S3ImageRepository
+	kafkaSrv          KafkaService
+	store             MetadataStore
+	notifier          *notifier
+	webhooks          *WebhookService
+	sanitizeOriginals bool
+	deduplicate       bool
+	limits            UploadLimits
+	batch             BatchConfig
+	remote            *remoteFetcher
 }
 
 // NewImageService creates a new ImageService instance.
-func NewImageService(s3Repo S3ImageRepository, kafkaSrv KafkaService) *ImageService {
+func NewImageService(s3Repo S3ImageRepository, kafkaSrv KafkaService, store MetadataStore, webhooks *WebhookService,
+	sanitizeOriginals, deduplicate bool, limits UploadLimits, batch BatchConfig, remote RemoteConfig) *ImageService {
+	if batch.Concurrency < 1 {
+		batch.Concurrency = 1
+	}
+
 	return &ImageService{
-		s3Repo:   s3Repo,
-		kafkaSrv: kafkaSrv,
+		s3Repo:            s3Repo,
+		kafkaSrv:          kafkaSrv,
+		store:             store,
+		notifier:          newNotifier(),
+		webhooks:          webhooks,
+		sanitizeOriginals: sanitizeOriginals,
+		deduplicate:       deduplicate,
+		limits:            limits,
+		batch:             batch,
+		remote:            newRemoteFetcher(remote),
 	}
 }
 
-// UploadImage uploads an image to S3 and publishes a message to Kafka.
-func (s *ImageService) UploadImage(image io.ReadSeeker) (*models.Image, error) {
+// UploadImage streams an image to S3 and publishes a message to Kafka.
+func (s *ImageService) UploadImage(src io.Reader, opts models.UploadOptions) (*models.Image, error) {
+	if opts.CallbackURL != "" {
+		if err := s.webhooks.ValidateCallbackURL(opts.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+
 	// Generate a unique ID for the image
 	id := uuid.New()
 
-	// Create a buffer for the image data
-	imageData := new(bytes.Buffer)
-	_, err := io.Copy(imageData, image)
+	// Refuse the unsupported types and decompression bombs before upload
+	upload, err := s.validateUpload(src)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read the image data: %v", err)
+		return nil, err
 	}
 
-	size := int64(imageData.Len())
+	return s.storeUpload(id, upload, opts)
+}
+
+// storeUpload stores the validated image as the original of the image ID, then records it and
+// requests its variants.
+func (s *ImageService) storeUpload(id uuid.UUID, upload *validatedUpload, opts models.UploadOptions) (*models.Image, error) {
+	// Stream the original image to S3, checksumming it on the way. When deduplicating, the image
+	// is spooled and checksummed first, so that a duplicate is returned without being stored.
+	var data io.Reader = upload.data
+	if s.deduplicates(opts) {
+		file, err := s.spool(upload.data)
+		if sizeErr := upload.sizeError(); sizeErr != nil {
+			return nil, sizeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the image data: %v", err)
+		}
+		defer removeSpool(file)
 
-	// Upload the original image to S3
-	originalImageURL, err := s.s3Repo.UploadImage(id, image)
+		existing, err := s.findDuplicate(upload.data.checksums())
+		if err != nil || existing != nil {
+			return existing, err
+		}
+		data = file
+	}
+	originalImageURL, err := s.s3Repo.UploadImage(id, data, upload.contentType)
+	if sizeErr := upload.sizeError(); sizeErr != nil {
+		return nil, sizeErr
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload the original image to S3: %v", err)
 	}
 
+	return s.registerImage(id, originalImageURL, upload, opts)
+}
+
+// registerImage records the original image stored in S3 and requests its variants, unless it is
+// a duplicate of an earlier image. The duplicates uploaded directly to S3, or concurrently with the
+// earlier image, are only found once stored, and are deleted.
+func (s *ImageService) registerImage(id uuid.UUID, originalImageURL string, upload *validatedUpload,
+	opts models.UploadOptions) (*models.Image, error) {
+	checksums := upload.data.checksums()
+
+	if s.deduplicates(opts) {
+		existing, err := s.findDuplicate(checksums)
+		if existing != nil {
+			if deleteErr := s.s3Repo.DeleteImage(id); deleteErr != nil {
+				logrus.Errorf("error occured while deleting duplicate image %s: %v", id, deleteErr)
+			}
+		}
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	if err := s.s3Repo.StoreChecksums(id, upload.contentType, checksums); err != nil {
+		if deleteErr := s.s3Repo.DeleteImage(id); deleteErr != nil {
+			logrus.Errorf("error occured while deleting image %s: %v", id, deleteErr)
+		}
+		return nil, fmt.Errorf("failed to verify the original image in S3: %v", err)
+	}
+
+	// Record the image before requesting the variants, so the resizer event always finds it
+	now := time.Now().UTC()
+	record := &models.ImageRecord{
+		ID:          id,
+		Status:      models.StatusUploaded,
+		ContentType: upload.contentType,
+		Size:        upload.data.n,
+		Checksum:    "sha256:" + checksums.SHA256,
+		Width:       upload.config.Width,
+		Height:      upload.config.Height,
+		CallbackURL: opts.CallbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.store.CreateImage(context.Background(), record); err != nil {
+		return nil, fmt.Errorf("failed to store the image record: %v", err)
+	}
+
+	sanitizeOriginal := s.sanitizeOriginals
+	if opts.SanitizeOriginal != nil {
+		sanitizeOriginal = *opts.SanitizeOriginal
+	}
+
 	// Send a message to Kafka to generate image variants
-	err = s.kafkaSrv.SendMessage(context.Background(), id)
+	err := s.kafkaSrv.SendMessage(context.Background(), id, sanitizeOriginal)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send message to Kafka: %v", err)
+		err = fmt.Errorf("failed to send message to Kafka: %v", err)
+		changed, statusErr := s.store.SetStatus(context.Background(), id, models.StatusFailed, err.Error())
+		if statusErr != nil {
+			logrus.Errorf("error occured while updating the status of image %s: %v", id, statusErr)
+		}
+		s.notify(context.Background(), id)
+		if changed {
+			s.enqueueWebhooks(context.Background(), id, models.StatusFailed)
+		}
+		return nil, err
+	}
+
+	// The resizer may already have reported the image, in which case the state is kept
+	if _, err = s.store.SetStatus(context.Background(), id, models.StatusQueued, ""); err != nil {
+		return nil, fmt.Errorf("failed to update the image status: %v", err)
 	}
+	s.notify(context.Background(), id)
 
 	// Create and return the image model
 	imageModel := &models.Image{
 		ID:          id,
-		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
-		Name:        "original",
+		CreatedAt:   now.Format(time.RFC3339),
+		Name:        models.OriginalVariant,
 		URL:         originalImageURL,
-		ContentType: http.DetectContentType(imageData.Bytes()),
-		Size:        size,
-		Content:     imageData.Bytes(),
+		ContentType: record.ContentType,
+		Size:        record.Size,
+		Checksums:   &checksums,
 	}
 	return imageModel, nil
 }
 
-// GetImage retrieves an image from S3.
-func (s *ImageService) GetImage(id uuid.UUID) (*models.Image, error) {
-	return s.s3Repo.GetImage(id, "original")
+// deduplicates reports whether the upload is deduplicated, by default or as requested.
+func (s *ImageService) deduplicates(opts models.UploadOptions) bool {
+	if opts.Deduplicate != nil {
+		return *opts.Deduplicate
+	}
+	return s.deduplicate
+}
+
+// findDuplicate looks up an earlier image with the same content and returns it with its variants,
+// or nil when there is none.
+func (s *ImageService) findDuplicate(checksums models.Checksums) (*models.Image, error) {
+	record, err := s.store.FindImageByChecksum(context.Background(), "sha256:"+checksums.SHA256)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up duplicate images: %v", err)
+	}
+
+	if record, err = s.getRecord(context.Background(), record.ID); err != nil {
+		return nil, err
+	}
+	url, err := s.s3Repo.PresignURL(record.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Image{
+		ID:           record.ID,
+		CreatedAt:    record.CreatedAt.Format(time.RFC3339),
+		Name:         models.OriginalVariant,
+		URL:          url,
+		ContentType:  record.ContentType,
+		Size:         record.Size,
+		Checksums:    &checksums,
+		Deduplicated: true,
+		Status:       record.Status,
+		Variants:     record.Variants,
+	}, nil
+}
+
+// GetImage retrieves the original image or one of its variants from S3.
+func (s *ImageService) GetImage(id uuid.UUID, variantName string) (*models.Image, error) {
+	if !models.ValidVariantName(variantName) {
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidVariant, variantName)
+	}
+	return s.s3Repo.GetImage(id, variantName)
 }
 
 // GetImageVariants retrieves the image variants from S3.
 func (s *ImageService) GetImageVariants(ids []string) ([]*models.Image, error) {
 	return s.s3Repo.GetImageVariants(ids)
 }
+
+// GetImageStatus returns the stored record of an original image with its processing state.
+func (s *ImageService) GetImageStatus(id uuid.UUID) (*models.ImageRecord, error) {
+	return s.store.GetImage(context.Background(), id)
+}
+
+// ListImageVariants returns the stored record of an original image with presigned URLs of its variants.
+func (s *ImageService) ListImageVariants(id uuid.UUID) (*models.ImageRecord, error) {
+	return s.getRecord(context.Background(), id)
+}
+
+// WatchImage returns the current record of an image and a channel receiving the record on every
+// state change, until the returned function is called.
+func (s *ImageService) WatchImage(id uuid.UUID) (*models.ImageRecord, <-chan *models.ImageRecord, func(), error) {
+	// Subscribe before reading the record, so no change in between is missed
+	updates, stop := s.notifier.subscribe(id)
+
+	record, err := s.getRecord(context.Background(), id)
+	if err != nil {
+		stop()
+		return nil, nil, nil, err
+	}
+
+	return record, updates, stop, nil
+}
+
+// notify sends the current record of the image to its watchers.
+func (s *ImageService) notify(ctx context.Context, id uuid.UUID) {
+	if !s.notifier.watched(id) {
+		return
+	}
+
+	record, err := s.getRecord(ctx, id)
+	if err != nil {
+		logrus.Errorf("error occured while loading image %s for its watchers: %v", id, err)
+		return
+	}
+	s.notifier.publish(record)
+}
+
+// getRecord returns the stored record of an image with presigned URLs of its variants.
+func (s *ImageService) getRecord(ctx context.Context, id uuid.UUID) (*models.ImageRecord, error) {
+	record, err := s.store.GetImage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range record.Variants {
+		url, err := s.s3Repo.PresignURL(record.Variants[i].Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign the %s variant URL: %v", record.Variants[i].Name, err)
+		}
+		record.Variants[i].URL = url
+	}
+
+	return record, nil
+}
+
+// checksumReader counts and checksums the bytes read through it.
+type checksumReader struct {
+	r      io.Reader
+	n      int64
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{r: r, sha256: sha256.New(), md5: md5.New()}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.sha256.Write(p[:n])
+	c.md5.Write(p[:n])
+	return n, err
+}
+
+// checksums returns the checksums of the bytes read so far.
+func (c *checksumReader) checksums() models.Checksums {
+	return models.Checksums{
+		SHA256: hex.EncodeToString(c.sha256.Sum(nil)),
+		MD5:    base64.StdEncoding.EncodeToString(c.md5.Sum(nil)),
+	}
+}
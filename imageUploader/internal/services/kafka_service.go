@@ -2,84 +2,58 @@ package services
 
 import (
 	"context"
+	"github.com/demius1992/Image-service/bus"
 	"github.com/google/uuid"
-	"github.com/segmentio/kafka-go"
-	"github.com/sirupsen/logrus"
-	"time"
 )
 
 type kafkaRepo struct {
-	writer      *kafka.Writer
-	reader      *kafka.Reader
-	inputTopic  string
-	outputTopic string
+	bus          bus.Bus
+	subscription bus.Subscription
+	inputTopic   string
+	outputTopic  string
 }
 
-func NewKafkaService(brokers []string, inputTopic, outputTopic string) KafkaService {
-	w := &kafka.Writer{
-		Addr:                   kafka.TCP(brokers...),
-		Topic:                  outputTopic,
-		Balancer:               &kafka.LeastBytes{},
-		AllowAutoTopicCreation: true,
+// NewKafkaService creates a service that publishes resize requests to the output topic of the
+// message bus and consumes the resizer events from the input topic as a member of the consumer group.
+func NewKafkaService(messages bus.Bus, inputTopic, outputTopic, groupID string) (KafkaService, error) {
+	subscription, err := messages.Subscribe(inputTopic, groupID)
+	if err != nil {
+		return nil, err
 	}
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  brokers,
-		Topic:    inputTopic,
-		GroupID:  "my-group",
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
-	})
 
 	return &kafkaRepo{
-		writer:      w,
-		reader:      r,
-		inputTopic:  inputTopic,
-		outputTopic: outputTopic,
-	}
+		bus:          messages,
+		subscription: subscription,
+		inputTopic:   inputTopic,
+		outputTopic:  outputTopic,
+	}, nil
 }
 
-// GetMessages gets messages from Kafka
-func (r *kafkaRepo) GetMessages() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	defer func() {
-		if err := r.reader.Close(); err != nil {
-			logrus.Printf("Failed to close Kafka reader: %v", err)
-		}
-	}()
-
-	var messages []string
+// FetchMessage reads the next resizer event without acknowledging it.
+func (r *kafkaRepo) FetchMessage(ctx context.Context) (*bus.Message, error) {
+	return r.subscription.Receive(ctx)
+}
 
-	for {
-		msg, err := r.reader.ReadMessage(ctx)
-		if err != nil {
-			if err == context.DeadlineExceeded {
-				break
-			}
-			return nil, err
-		}
+// CommitMessages acknowledges the handled events.
+func (r *kafkaRepo) CommitMessages(ctx context.Context, msgs ...*bus.Message) error {
+	return r.subscription.Ack(ctx, msgs...)
+}
 
-		//Gets ids from message keys
-		messages = append(messages, string(msg.Key))
+// SendMessage publishes a resize request.
+func (r *kafkaRepo) SendMessage(ctx context.Context, id uuid.UUID, sanitizeOriginal bool) error {
+	message := &bus.Message{
+		Key:   []byte(id.String()),
+		Value: []byte("empty value"),
+	}
+	if sanitizeOriginal {
+		// Asks the resizer to also store a copy of the original without metadata
+		message.Headers = map[string]string{"sanitize-original": "true"}
 	}
 
-	return messages, nil
+	return r.bus.Publish(ctx, r.outputTopic, message)
 }
 
-// SendMessage sends a message to Kafka.
-func (r *kafkaRepo) SendMessage(ctx context.Context, id uuid.UUID) error {
-	messageKey := []byte(id.String())
-	messageValue := []byte("empty value")
-
-	err := r.writer.WriteMessages(ctx, kafka.Message{
-		Key:   messageKey,
-		Value: messageValue,
-	})
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+// Close leaves the consumer group.
+func (r *kafkaRepo) Close() error {
+	return r.subscription.Close()
 }
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"sync"
+)
+
+// notifier fans the image record updates out to the subscribers watching the image.
+type notifier struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan *models.ImageRecord]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{subscribers: make(map[uuid.UUID]map[chan *models.ImageRecord]struct{})}
+}
+
+// subscribe returns the channel receiving the updates of the image and the function that stops them.
+func (n *notifier) subscribe(id uuid.UUID) (<-chan *models.ImageRecord, func()) {
+	// Every update holds the whole record, so a subscriber only needs the latest one
+	ch := make(chan *models.ImageRecord, 1)
+
+	n.mu.Lock()
+	if n.subscribers[id] == nil {
+		n.subscribers[id] = make(map[chan *models.ImageRecord]struct{})
+	}
+	n.subscribers[id][ch] = struct{}{}
+	n.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			delete(n.subscribers[id], ch)
+			if len(n.subscribers[id]) == 0 {
+				delete(n.subscribers, id)
+			}
+		})
+	}
+}
+
+// watched reports whether anyone is subscribed to the image.
+func (n *notifier) watched(id uuid.UUID) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subscribers[id]) > 0
+}
+
+// publish sends the record to the subscribers of the image without blocking. An update that the
+// subscriber has not received yet is replaced.
+func (n *notifier) publish(record *models.ImageRecord) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subscribers[record.ID] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- record
+	}
+}
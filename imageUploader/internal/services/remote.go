@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// RemoteConfig bounds the downloads of remote images.
+type RemoteConfig struct {
+	// Timeout bounds the whole download, including the redirects and the body
+	Timeout time.Duration
+	// MaxRedirects is the number of redirects followed
+	MaxRedirects int
+}
+
+// blockedNetworks are the special-purpose ranges not covered by the net.IP predicates.
+var blockedNetworks = parseCIDRs(
+	"0.0.0.0/8",       // this network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, and the broadcast address
+	"64:ff9b::/96",    // NAT64, which can reach private IPv4 addresses
+	"2001:db8::/32",   // documentation
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// publicIP reports whether the IP address is routable on the internet. Loopback, private,
+// link-local (which holds the cloud metadata endpoints), multicast and reserved addresses are not.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteFetcher downloads remote images, only connecting to public addresses.
+type remoteFetcher struct {
+	client *http.Client
+}
+
+// newPublicTransport creates a transport only connecting to public addresses, failing the other
+// connections with errNotPublic. The addresses are checked when the connections are made, after the
+// host names are resolved, so that a host cannot resolve to a public address when validated and to
+// an internal one when connected to. No proxy is used, since it would connect to the addresses on
+// our behalf.
+func newPublicTransport(errNotPublic error, responseHeaderTimeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s is not a public address", errNotPublic, host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// newRemoteFetcher creates a fetcher with the given limits.
+func newRemoteFetcher(cfg RemoteConfig) *remoteFetcher {
+	return &remoteFetcher{
+		client: &http.Client{
+			Transport: newPublicTransport(models.ErrInvalidSourceURL, cfg.Timeout),
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > cfg.MaxRedirects {
+					return fmt.Errorf("%w: more than %d redirects", models.ErrFetchFailed, cfg.MaxRedirects)
+				}
+				return validateSourceURL(req.URL)
+			},
+		},
+	}
+}
+
+// UploadImageFromURL downloads the image at the URL and uploads it like UploadImage.
+func (s *ImageService) UploadImageFromURL(ctx context.Context, sourceURL string,
+	opts models.UploadOptions) (*models.Image, error) {
+	if opts.CallbackURL != "" {
+		if err := s.webhooks.ValidateCallbackURL(opts.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := s.remote.fetch(ctx, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if s.limits.MaxBytes > 0 && resp.ContentLength > s.limits.MaxBytes {
+		return nil, fmt.Errorf("%w: the image exceeds %d bytes", models.ErrTooLarge, s.limits.MaxBytes)
+	}
+
+	return s.UploadImage(resp.Body, opts)
+}
+
+// fetch starts the download of the image at the URL. The body of the response must be closed.
+func (f *remoteFetcher) fetch(ctx context.Context, sourceURL string) (*http.Response, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidSourceURL, sourceURL)
+	}
+	if err = validateSourceURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidSourceURL, sourceURL)
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidSourceURL) || errors.Is(err, models.ErrFetchFailed) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", models.ErrFetchFailed, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: the server responded with %s", models.ErrFetchFailed, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// validateSourceURL only accepts the HTTP and HTTPS URLs without credentials.
+func validateSourceURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%w: %q", models.ErrInvalidSourceURL, u.Redacted())
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResumableStorage provides an interface for assembling the resumable uploads in S3.
+type ResumableStorage interface {
+	CreateMultipartUpload(id uuid.UUID) (string, error)
+	UploadPart(id uuid.UUID, multipartID string, number int, data []byte) error
+	CompleteMultipartUpload(id uuid.UUID, multipartID string) error
+	AbortMultipartUpload(id uuid.UUID, multipartID string) error
+	PutPendingPart(id uuid.UUID, data []byte) error
+	GetPendingPart(id uuid.UUID, buf []byte) error
+	DeletePendingPart(id uuid.UUID) error
+}
+
+// UploadStore provides an interface for persisting the progress of the resumable uploads.
+type UploadStore interface {
+	CreateUpload(ctx context.Context, upload *models.ResumableUpload) error
+	GetUpload(ctx context.Context, id uuid.UUID) (*models.ResumableUpload, error)
+	UpdateUpload(ctx context.Context, upload *models.ResumableUpload) error
+	DeleteUpload(ctx context.Context, id uuid.UUID) error
+}
+
+const (
+	// resumablePartSize is the size of the parts of the S3 multipart uploads, the minimum S3 accepts
+	resumablePartSize = 5 << 20
+	// maxResumableParts is the number of parts an S3 multipart upload can hold
+	maxResumableParts = 10000
+)
+
+// ResumableUploadService receives the images uploaded in chunks. The chunks are assembled into
+// parts of an S3 multipart upload; the bytes that do not fill a part yet are kept aside in S3, so
+// an upload can be resumed from any offset after a failure or a restart. Complete uploads are
+// processed like the direct uploads.
+type ResumableUploadService struct {
+	storage ResumableStorage
+	store   UploadStore
+	images  *ImageService
+
+	mu sync.Mutex
+	// busy holds the uploads a chunk is being written to
+	busy map[uuid.UUID]bool
+}
+
+// NewResumableUploadService creates a new ResumableUploadService instance.
+func NewResumableUploadService(storage ResumableStorage, store UploadStore, images *ImageService) *ResumableUploadService {
+	return &ResumableUploadService{
+		storage: storage,
+		store:   store,
+		images:  images,
+		busy:    make(map[uuid.UUID]bool),
+	}
+}
+
+// MaxSize returns the size limit of the uploads, or zero when there is none.
+func (s *ResumableUploadService) MaxSize() int64 {
+	return s.images.limits.MaxBytes
+}
+
+// CreateUpload starts an upload of the given length. The metadata is the Upload-Metadata header
+// of the tus protocol, whose sanitize, deduplicate and callback_url keys set the upload options.
+func (s *ResumableUploadService) CreateUpload(length int64, metadata string) (*models.ResumableUpload, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("%w: empty upload", models.ErrInvalidUploadMetadata)
+	}
+	maxSize := int64(resumablePartSize * maxResumableParts)
+	if s.MaxSize() > 0 && s.MaxSize() < maxSize {
+		maxSize = s.MaxSize()
+	}
+	if length > maxSize {
+		return nil, fmt.Errorf("%w: the image exceeds %d bytes", models.ErrTooLarge, maxSize)
+	}
+
+	opts, err := parseUploadMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CallbackURL != "" {
+		if err = s.images.webhooks.ValidateCallbackURL(opts.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	id := uuid.New()
+	multipartID, err := s.storage.CreateMultipartUpload(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the multipart upload: %v", err)
+	}
+
+	now := time.Now().UTC()
+	upload := &models.ResumableUpload{
+		ID:          id,
+		MultipartID: multipartID,
+		Length:      length,
+		Metadata:    metadata,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err = s.store.CreateUpload(context.Background(), upload); err != nil {
+		if abortErr := s.storage.AbortMultipartUpload(id, multipartID); abortErr != nil {
+			logrus.Errorf("error occured while aborting the multipart upload of %s: %v", id, abortErr)
+		}
+		return nil, fmt.Errorf("failed to store the upload: %v", err)
+	}
+
+	return upload, nil
+}
+
+// GetUpload returns the progress of an upload.
+func (s *ResumableUploadService) GetUpload(id uuid.UUID) (*models.ResumableUpload, error) {
+	return s.store.GetUpload(context.Background(), id)
+}
+
+// WriteChunk appends the chunk to the upload, which must stop at offset. The progress is stored
+// after every part, so that the received bytes are kept when the chunk is interrupted. Once the
+// upload is complete, the image is processed; an empty chunk can be written to a complete upload
+// to retry a failed processing.
+func (s *ResumableUploadService) WriteChunk(id uuid.UUID, offset int64, chunk io.Reader) (*models.ResumableUpload, error) {
+	if !s.acquire(id) {
+		return nil, fmt.Errorf("%w: %s", models.ErrUploadLocked, id)
+	}
+	defer s.release(id)
+
+	upload, err := s.store.GetUpload(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, fmt.Errorf("%w: the upload stopped at %d", models.ErrOffsetMismatch, upload.Offset)
+	}
+
+	if upload.Offset < upload.Length {
+		if err = s.writeParts(upload, io.LimitReader(chunk, upload.Length-upload.Offset)); err != nil {
+			return upload, err
+		}
+	}
+
+	if upload.Offset == upload.Length {
+		if err = s.complete(upload); err != nil {
+			return upload, err
+		}
+	}
+
+	return upload, nil
+}
+
+// writeParts uploads the parts filled by the chunk, and keeps the bytes of the last incomplete one
+// aside. It returns the error that interrupted the chunk, if any.
+func (s *ResumableUploadService) writeParts(upload *models.ResumableUpload, chunk io.Reader) error {
+	part := make([]byte, resumablePartSize)
+	filled := int(upload.Pending)
+	if filled > 0 {
+		if err := s.storage.GetPendingPart(upload.ID, part[:filled]); err != nil {
+			return fmt.Errorf("failed to read the pending part: %v", err)
+		}
+	}
+
+	var readErr error
+	for {
+		var n int
+		n, readErr = io.ReadFull(chunk, part[filled:])
+		filled += n
+		upload.Offset += int64(n)
+
+		// Only the last part may be smaller than the part size
+		if filled < len(part) && upload.Offset < upload.Length {
+			break
+		}
+
+		if err := s.storage.UploadPart(upload.ID, upload.MultipartID, upload.Parts+1, part[:filled]); err != nil {
+			upload.Offset -= int64(filled) - upload.Pending
+			return fmt.Errorf("failed to upload part %d: %v", upload.Parts+1, err)
+		}
+		upload.Parts++
+		filled, upload.Pending = 0, 0
+		if err := s.saveProgress(upload); err != nil {
+			return err
+		}
+		if upload.Offset == upload.Length || readErr != nil {
+			break
+		}
+	}
+
+	if int64(filled) != upload.Pending {
+		if err := s.storage.PutPendingPart(upload.ID, part[:filled]); err != nil {
+			upload.Offset -= int64(filled) - upload.Pending
+			return fmt.Errorf("failed to keep the pending part: %v", err)
+		}
+		upload.Pending = int64(filled)
+		if err := s.saveProgress(upload); err != nil {
+			return err
+		}
+	}
+
+	if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return readErr
+}
+
+// saveProgress stores the offset reached by the upload.
+func (s *ResumableUploadService) saveProgress(upload *models.ResumableUpload) error {
+	upload.UpdatedAt = time.Now().UTC()
+	if err := s.store.UpdateUpload(context.Background(), upload); err != nil {
+		return fmt.Errorf("failed to store the upload progress: %v", err)
+	}
+	return nil
+}
+
+// complete assembles the parts of the upload into the staged image and processes it.
+func (s *ResumableUploadService) complete(upload *models.ResumableUpload) error {
+	if upload.MultipartID != "" {
+		if err := s.storage.CompleteMultipartUpload(upload.ID, upload.MultipartID); err != nil {
+			return fmt.Errorf("failed to complete the multipart upload: %v", err)
+		}
+		upload.MultipartID = ""
+		if err := s.saveProgress(upload); err != nil {
+			return err
+		}
+		s.deletePendingPart(upload.ID)
+	}
+
+	opts, err := parseUploadMetadata(upload.Metadata)
+	if err != nil {
+		return err
+	}
+	if err = s.images.checkNotCompleted(upload.ID); err != nil {
+		if errors.Is(err, models.ErrUploadCompleted) {
+			return nil
+		}
+		return err
+	}
+	_, err = s.images.processStagedUpload(upload.ID, opts)
+	return err
+}
+
+// DeleteUpload terminates an upload and discards the received bytes. The image of a complete
+// upload is kept.
+func (s *ResumableUploadService) DeleteUpload(id uuid.UUID) error {
+	if !s.acquire(id) {
+		return fmt.Errorf("%w: %s", models.ErrUploadLocked, id)
+	}
+	defer s.release(id)
+
+	upload, err := s.store.GetUpload(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	if upload.MultipartID != "" {
+		if err = s.storage.AbortMultipartUpload(id, upload.MultipartID); err != nil {
+			return fmt.Errorf("failed to abort the multipart upload: %v", err)
+		}
+	}
+	s.deletePendingPart(id)
+
+	return s.store.DeleteUpload(context.Background(), id)
+}
+
+// deletePendingPart removes the bytes kept aside for the upload, if any.
+func (s *ResumableUploadService) deletePendingPart(id uuid.UUID) {
+	if err := s.storage.DeletePendingPart(id); err != nil {
+		logrus.Errorf("error occured while deleting the pending part of %s: %v", id, err)
+	}
+}
+
+// acquire marks the upload as being written to, unless it already is.
+func (s *ResumableUploadService) acquire(id uuid.UUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *ResumableUploadService) release(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.busy, id)
+}
+
+// parseUploadMetadata reads the upload options from an Upload-Metadata header, a comma separated
+// list of keys followed by their base64 value. The other keys, such as filename, are ignored.
+func parseUploadMetadata(metadata string) (models.UploadOptions, error) {
+	var opts models.UploadOptions
+	if metadata == "" {
+		return opts, nil
+	}
+
+	for _, pair := range strings.Split(metadata, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if key == "" || err != nil {
+			return opts, fmt.Errorf("%w: %q", models.ErrInvalidUploadMetadata, pair)
+		}
+
+		switch key {
+		case "sanitize", "deduplicate":
+			flag, err := strconv.ParseBool(string(value))
+			if err != nil {
+				return opts, fmt.Errorf("%w: invalid %s value", models.ErrInvalidUploadMetadata, key)
+			}
+			if key == "sanitize" {
+				opts.SanitizeOriginal = &flag
+			} else {
+				opts.Deduplicate = &flag
+			}
+		case "callback_url":
+			opts.CallbackURL = string(value)
+		}
+	}
+
+	return opts, nil
+}
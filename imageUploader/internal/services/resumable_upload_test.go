@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+)
+
+// memoryParts keeps the parts and the pending bytes of a resumable upload in memory, failing the
+// part or the pending bytes it is told to.
+type memoryParts struct {
+	parts   map[int][]byte
+	pending []byte
+	// failPart is the number of the part failing to upload
+	failPart    int
+	failPending bool
+}
+
+func (m *memoryParts) CreateMultipartUpload(uuid.UUID) (string, error) { return "multipart", nil }
+
+func (m *memoryParts) UploadPart(_ uuid.UUID, _ string, number int, data []byte) error {
+	if number == m.failPart {
+		return errors.New("part upload failed")
+	}
+	m.parts[number] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memoryParts) CompleteMultipartUpload(uuid.UUID, string) error { return nil }
+
+func (m *memoryParts) AbortMultipartUpload(uuid.UUID, string) error { return nil }
+
+func (m *memoryParts) PutPendingPart(_ uuid.UUID, data []byte) error {
+	if m.failPending {
+		return errors.New("pending part upload failed")
+	}
+	m.pending = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memoryParts) GetPendingPart(_ uuid.UUID, buf []byte) error {
+	if len(buf) != len(m.pending) {
+		return io.ErrUnexpectedEOF
+	}
+	copy(buf, m.pending)
+	return nil
+}
+
+func (m *memoryParts) DeletePendingPart(uuid.UUID) error {
+	m.pending = nil
+	return nil
+}
+
+// memoryUploads stores the progress of the resumable uploads in memory.
+type memoryUploads struct {
+	uploads map[uuid.UUID]models.ResumableUpload
+}
+
+func (m *memoryUploads) CreateUpload(_ context.Context, upload *models.ResumableUpload) error {
+	m.uploads[upload.ID] = *upload
+	return nil
+}
+
+func (m *memoryUploads) GetUpload(_ context.Context, id uuid.UUID) (*models.ResumableUpload, error) {
+	upload, ok := m.uploads[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &upload, nil
+}
+
+func (m *memoryUploads) UpdateUpload(_ context.Context, upload *models.ResumableUpload) error {
+	m.uploads[upload.ID] = *upload
+	return nil
+}
+
+func (m *memoryUploads) DeleteUpload(_ context.Context, id uuid.UUID) error {
+	delete(m.uploads, id)
+	return nil
+}
+
+// failingReader returns the bytes of r, then fails.
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestWriteParts(t *testing.T) {
+	const mb = 1 << 20
+
+	// A chunk writes size bytes, and expects the upload progress afterwards
+	type chunk struct {
+		size int
+		// interrupted chunks fail after their bytes are read
+		interrupted bool
+		offset      int64
+		parts       int
+		pending     int64
+		err         bool
+	}
+
+	tests := []struct {
+		name   string
+		length int64
+		// failPart and failPending fail the storage of a part or of the pending bytes
+		failPart    int
+		failPending bool
+		chunks      []chunk
+		// partSizes are the sizes of the uploaded parts
+		partSizes []int
+	}{
+		{
+			name: "chunk ending mid-part", length: 12 * mb,
+			chunks:    []chunk{{size: 3 * mb, offset: 3 * mb, pending: 3 * mb}},
+			partSizes: []int{},
+		},
+		{
+			name: "chunks filling a part across requests", length: 12 * mb,
+			chunks: []chunk{
+				{size: 3 * mb, offset: 3 * mb, pending: 3 * mb},
+				{size: 3 * mb, offset: 6 * mb, parts: 1, pending: 1 * mb},
+			},
+			partSizes: []int{5 * mb},
+		},
+		{
+			name: "chunk ending on a part boundary", length: 12 * mb,
+			chunks: []chunk{
+				{size: 5 * mb, offset: 5 * mb, parts: 1},
+				{size: 5 * mb, offset: 10 * mb, parts: 2},
+			},
+			partSizes: []int{5 * mb, 5 * mb},
+		},
+		{
+			name: "chunk holding several parts", length: 12 * mb,
+			chunks:    []chunk{{size: 11 * mb, offset: 11 * mb, parts: 2, pending: 1 * mb}},
+			partSizes: []int{5 * mb, 5 * mb},
+		},
+		{
+			name: "short final part", length: 7 * mb,
+			chunks:    []chunk{{size: 7 * mb, offset: 7 * mb, parts: 2}},
+			partSizes: []int{5 * mb, 2 * mb},
+		},
+		{
+			name: "short final part completing the pending bytes", length: 7 * mb,
+			chunks: []chunk{
+				{size: 6 * mb, offset: 6 * mb, parts: 1, pending: 1 * mb},
+				{size: 1 * mb, offset: 7 * mb, parts: 2},
+			},
+			partSizes: []int{5 * mb, 2 * mb},
+		},
+		{
+			name: "single short part", length: 100,
+			chunks: []chunk{
+				{size: 40, offset: 40, pending: 40},
+				{size: 60, offset: 100, parts: 1},
+			},
+			partSizes: []int{100},
+		},
+		{
+			name: "interrupted chunk keeps the received bytes", length: 12 * mb,
+			chunks: []chunk{
+				{size: 7 * mb, interrupted: true, offset: 7 * mb, parts: 1, pending: 2 * mb, err: true},
+				{size: 3 * mb, offset: 10 * mb, parts: 2},
+			},
+			partSizes: []int{5 * mb, 5 * mb},
+		},
+		{
+			name: "failed part rolls the offset back", length: 12 * mb, failPart: 1,
+			chunks: []chunk{
+				{size: 2 * mb, offset: 2 * mb, pending: 2 * mb},
+				{size: 4 * mb, offset: 2 * mb, pending: 2 * mb, err: true},
+			},
+			partSizes: []int{},
+		},
+		{
+			name: "failed later part keeps the earlier ones", length: 12 * mb, failPart: 2,
+			chunks:    []chunk{{size: 12 * mb, offset: 5 * mb, parts: 1, err: true}},
+			partSizes: []int{5 * mb},
+		},
+		{
+			name: "failed pending bytes roll the offset back", length: 12 * mb, failPending: true,
+			chunks:    []chunk{{size: 8 * mb, offset: 5 * mb, parts: 1, err: true}},
+			partSizes: []int{5 * mb},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := &memoryParts{parts: make(map[int][]byte), failPart: tt.failPart, failPending: tt.failPending}
+			store := &memoryUploads{uploads: make(map[uuid.UUID]models.ResumableUpload)}
+			s := NewResumableUploadService(parts, store, nil)
+
+			upload := &models.ResumableUpload{ID: uuid.New(), MultipartID: "multipart", Length: tt.length}
+			if err := store.CreateUpload(context.Background(), upload); err != nil {
+				t.Fatal(err)
+			}
+
+			// The content is a byte pattern, so misplaced bytes are noticed
+			content := make([]byte, tt.length)
+			for i := range content {
+				content[i] = byte(i % 251)
+			}
+
+			for i, c := range tt.chunks {
+				start := upload.Offset
+				var r io.Reader = bytes.NewReader(content[start : start+int64(c.size)])
+				if c.interrupted {
+					r = &failingReader{r: r}
+				}
+
+				err := s.writeParts(upload, io.LimitReader(r, upload.Length-upload.Offset))
+				if c.err != (err != nil) {
+					t.Fatalf("chunk %d: writeParts() = %v, want an error: %v", i, err, c.err)
+				}
+				if upload.Offset != c.offset || upload.Parts != c.parts || upload.Pending != c.pending {
+					t.Fatalf("chunk %d: offset %d, %d parts, %d pending bytes, want offset %d, %d parts, %d pending bytes",
+						i, upload.Offset, upload.Parts, upload.Pending, c.offset, c.parts, c.pending)
+				}
+
+				// The stored progress is the one a resumed upload starts from
+				stored, _ := store.GetUpload(context.Background(), upload.ID)
+				if *stored != *upload {
+					t.Fatalf("chunk %d: stored progress %+v, want %+v", i, *stored, *upload)
+				}
+				upload = stored
+			}
+
+			// The parts and the pending bytes hold the received content in order
+			var received []byte
+			if len(parts.parts) != len(tt.partSizes) {
+				t.Fatalf("uploaded %d parts, want %d", len(parts.parts), len(tt.partSizes))
+			}
+			for number := 1; number <= len(tt.partSizes); number++ {
+				if len(parts.parts[number]) != tt.partSizes[number-1] {
+					t.Fatalf("part %d holds %d bytes, want %d", number, len(parts.parts[number]), tt.partSizes[number-1])
+				}
+				received = append(received, parts.parts[number]...)
+			}
+			received = append(received, parts.pending[:upload.Pending]...)
+			if !bytes.Equal(received, content[:upload.Offset]) {
+				t.Errorf("the parts and the pending bytes do not hold the first %d bytes of the content", upload.Offset)
+			}
+		})
+	}
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		metadata string
+		want     models.UploadOptions
+		err      bool
+	}{
+		{name: "empty", metadata: ""},
+		{name: "ignored keys", metadata: "filename Y2F0LmpwZw==,filetype aW1hZ2UvanBlZw=="},
+		{name: "key without value", metadata: "is_confidential"},
+		{name: "spaces around pairs", metadata: " filename Y2F0 , sanitize ZmFsc2U= ", want: models.UploadOptions{SanitizeOriginal: &no}},
+		{
+			name:     "options",
+			metadata: "sanitize dHJ1ZQ==, deduplicate ZmFsc2U=,callback_url aHR0cHM6Ly9leGFtcGxlLmNvbS9ob29r",
+			want:     models.UploadOptions{SanitizeOriginal: &yes, Deduplicate: &no, CallbackURL: "https://example.com/hook"},
+		},
+
+		{name: "invalid base64", metadata: "filename not-base64!", err: true},
+		{name: "truncated base64", metadata: "filename Y2F", err: true},
+		{name: "extra value", metadata: "sanitize dHJ1ZQ== dHJ1ZQ==", err: true},
+		{name: "empty pair", metadata: "filename Y2F0LmpwZw==,,sanitize dHJ1ZQ==", err: true},
+		{name: "trailing comma", metadata: "sanitize dHJ1ZQ==,", err: true},
+		{name: "invalid flag", metadata: "sanitize eWVz", err: true},
+		{name: "flag without value", metadata: "deduplicate", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseUploadMetadata(tt.metadata)
+			if tt.err {
+				if !errors.Is(err, models.ErrInvalidUploadMetadata) {
+					t.Fatalf("parseUploadMetadata() = %v, want %v", err, models.ErrInvalidUploadMetadata)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUploadMetadata() = %v", err)
+			}
+
+			if !equalFlag(opts.SanitizeOriginal, tt.want.SanitizeOriginal) ||
+				!equalFlag(opts.Deduplicate, tt.want.Deduplicate) || opts.CallbackURL != tt.want.CallbackURL {
+				t.Errorf("parseUploadMetadata() = %s, want %s", formatOptions(opts), formatOptions(tt.want))
+			}
+		})
+	}
+}
+
+func equalFlag(a, b *bool) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func formatOptions(opts models.UploadOptions) string {
+	flag := func(b *bool) string {
+		if b == nil {
+			return "unset"
+		}
+		if *b {
+			return "true"
+		}
+		return "false"
+	}
+	return "sanitize " + flag(opts.SanitizeOriginal) + ", deduplicate " + flag(opts.Deduplicate) +
+		", callback " + opts.CallbackURL
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"image"
+	"io"
+	"net/http"
+
+	// Register the decoders of the formats the resizer can read
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// sniffLen is the size of the upload prefix peeked to detect the content type.
+const sniffLen = 512
+
+// maxHeaderLen bounds the bytes read to find the dimensions of an upload.
+const maxHeaderLen = 1 << 20
+
+// DefaultAllowedTypes are the content types the resizer can decode.
+var DefaultAllowedTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/tiff"}
+
+// UploadLimits bounds the accepted uploads. Zero values disable the limit.
+type UploadLimits struct {
+	// AllowedTypes lists the accepted content types, detected from the magic bytes
+	AllowedTypes  []string
+	MaxBytes      int64
+	MaxWidth      int
+	MaxHeight     int
+	MaxMegapixels float64
+}
+
+// validatedUpload is an upload whose type and dimensions are within the limits.
+type validatedUpload struct {
+	contentType string
+	config      image.Config
+	// data streams the whole upload, computing its checksums
+	data    *checksumReader
+	limited *limitedReader
+}
+
+// validateUpload reads the type and the dimensions at the start of the upload and checks them
+// against the limits. The size is checked while the upload is read.
+func (s *ImageService) validateUpload(src io.Reader) (*validatedUpload, error) {
+	// Peek at the magic bytes of the image to detect its type
+	limited := &limitedReader{r: src, max: s.limits.MaxBytes}
+	reader := bufio.NewReaderSize(limited, sniffLen)
+	prefix, err := reader.Peek(sniffLen)
+	if errors.Is(err, models.ErrTooLarge) {
+		return nil, err
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read the image data: %v", err)
+	}
+	contentType := sniffContentType(prefix)
+	if err = s.limits.checkType(contentType); err != nil {
+		return nil, err
+	}
+
+	// Read the dimensions from the image header, so decompression bombs are refused before upload
+	config, body, err := decodeHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.limits.checkDimensions(config); err != nil {
+		return nil, err
+	}
+
+	return &validatedUpload{
+		contentType: contentType,
+		config:      config,
+		data:        newChecksumReader(body),
+		limited:     limited,
+	}, nil
+}
+
+// sizeError returns models.ErrTooLarge when more than the size limit was read from the upload.
+func (u *validatedUpload) sizeError() error {
+	if u.limited.exceeded() {
+		return fmt.Errorf("%w: the image exceeds %d bytes", models.ErrTooLarge, u.limited.max)
+	}
+	return nil
+}
+
+// sniffContentType detects the content type from the magic bytes, recognizing TIFF in addition
+// to the types known to http.DetectContentType.
+func sniffContentType(prefix []byte) string {
+	if bytes.HasPrefix(prefix, []byte("II*\x00")) || bytes.HasPrefix(prefix, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(prefix)
+}
+
+// checkType fails with models.ErrUnsupportedType unless the content type is allowed.
+func (l UploadLimits) checkType(contentType string) error {
+	if len(l.AllowedTypes) == 0 {
+		return nil
+	}
+	for _, allowed := range l.AllowedTypes {
+		if contentType == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", models.ErrUnsupportedType, contentType)
+}
+
+// checkDimensions fails with models.ErrTooLarge when the image declares too many pixels.
+func (l UploadLimits) checkDimensions(config image.Config) error {
+	if l.MaxWidth > 0 && config.Width > l.MaxWidth {
+		return fmt.Errorf("%w: width %d exceeds %d pixels", models.ErrTooLarge, config.Width, l.MaxWidth)
+	}
+	if l.MaxHeight > 0 && config.Height > l.MaxHeight {
+		return fmt.Errorf("%w: height %d exceeds %d pixels", models.ErrTooLarge, config.Height, l.MaxHeight)
+	}
+	megapixels := float64(config.Width) * float64(config.Height) / 1e6
+	if l.MaxMegapixels > 0 && megapixels > l.MaxMegapixels {
+		return fmt.Errorf("%w: %.1f megapixels exceed %.1f", models.ErrTooLarge, megapixels, l.MaxMegapixels)
+	}
+	return nil
+}
+
+// decodeHeader reads the dimensions of the image from the start of the stream. It returns the
+// dimensions and a reader yielding the whole stream again.
+func decodeHeader(r io.Reader) (image.Config, io.Reader, error) {
+	// The decoder buffers ahead, so everything it pulls from the stream is kept to be replayed
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(io.LimitReader(r, maxHeaderLen), &header))
+	if errors.Is(err, models.ErrTooLarge) {
+		return image.Config{}, nil, err
+	}
+	if err != nil {
+		return image.Config{}, nil, fmt.Errorf("%w: cannot read the image dimensions: %v", models.ErrUnsupportedType, err)
+	}
+	return config, io.MultiReader(&header, r), nil
+}
+
+// limitedReader fails with models.ErrTooLarge once more than max bytes are read.
+type limitedReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.max <= 0 {
+		return l.r.Read(p)
+	}
+	if l.n > l.max {
+		return 0, fmt.Errorf("%w: the image exceeds %d bytes", models.ErrTooLarge, l.max)
+	}
+	// Read one byte past the limit to tell an image of exactly max bytes from a larger one
+	if remaining := l.max + 1 - l.n; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, fmt.Errorf("%w: the image exceeds %d bytes", models.ErrTooLarge, l.max)
+	}
+	return n, err
+}
+
+// exceeded reports whether more than max bytes were read.
+func (l *limitedReader) exceeded() bool {
+	return l.max > 0 && l.n > l.max
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+)
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int64
+		size    int
+		tooLong bool
+	}{
+		{"empty", 100, 0, false},
+		{"below the limit", 100, 99, false},
+		{"at the limit", 100, 100, false},
+		{"one byte over the limit", 100, 101, true},
+		{"far over the limit", 100, 1 << 20, true},
+		{"no limit", 0, 1 << 20, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte{'x'}, tt.size)
+			limited := &limitedReader{r: bytes.NewReader(content), max: tt.max}
+
+			read, err := io.ReadAll(limited)
+			if tt.tooLong {
+				if !errors.Is(err, models.ErrTooLarge) {
+					t.Fatalf("read = %v, want %v", err, models.ErrTooLarge)
+				}
+				if int64(len(read)) > tt.max+1 {
+					t.Errorf("read %d bytes, want at most %d", len(read), tt.max+1)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("read = %v, want nil", err)
+				}
+				if len(read) != tt.size {
+					t.Errorf("read %d bytes, want %d", len(read), tt.size)
+				}
+			}
+			if limited.exceeded() != tt.tooLong {
+				t.Errorf("exceeded() = %v, want %v", limited.exceeded(), tt.tooLong)
+			}
+
+			// The limit keeps failing the reads once exceeded
+			if tt.tooLong {
+				if _, err = limited.Read(make([]byte, 1)); !errors.Is(err, models.ErrTooLarge) {
+					t.Errorf("read past the limit = %v, want %v", err, models.ErrTooLarge)
+				}
+			}
+		})
+	}
+}
+
+// encodeImage encodes a noisy image of the given size, so that it does not compress much.
+func encodeImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rnd := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rnd.Intn(256))
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngDeclaring returns a PNG whose header declares the given dimensions, with no pixel data.
+func pngDeclaring(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8 bits per sample, RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.WriteString("IHDR")
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("IHDR"), ihdr...)))
+	return buf.Bytes()
+}
+
+func TestValidateUpload(t *testing.T) {
+	pngImage := encodeImage(t, "png", 64, 48)
+	jpegImage := encodeImage(t, "jpeg", 64, 48)
+	gifImage := encodeImage(t, "gif", 8, 8)
+	size := int64(len(pngImage))
+
+	defaults := UploadLimits{AllowedTypes: DefaultAllowedTypes}
+	withMaxBytes := func(max int64) UploadLimits {
+		limits := defaults
+		limits.MaxBytes = max
+		return limits
+	}
+
+	tests := []struct {
+		name    string
+		limits  UploadLimits
+		content []byte
+		// contentType is the detected type of the valid uploads
+		contentType string
+		// err is the error of the validation, or of the read of the upload
+		err error
+	}{
+		{"png", defaults, pngImage, "image/png", nil},
+		{"jpeg", defaults, jpegImage, "image/jpeg", nil},
+		{"gif", defaults, gifImage, "image/gif", nil},
+		{"any type allowed", UploadLimits{}, pngImage, "image/png", nil},
+
+		{"below the size limit", withMaxBytes(size + 1), pngImage, "image/png", nil},
+		{"at the size limit", withMaxBytes(size), pngImage, "image/png", nil},
+		{"over the size limit", withMaxBytes(size - 1), pngImage, "", models.ErrTooLarge},
+		{"over the size limit in the sniffed prefix", withMaxBytes(100), gifImage, "", models.ErrTooLarge},
+		{"over the width limit", UploadLimits{MaxWidth: 63}, pngImage, "", models.ErrTooLarge},
+		{"over the height limit", UploadLimits{MaxHeight: 47}, pngImage, "", models.ErrTooLarge},
+		{"over the megapixel limit", UploadLimits{MaxMegapixels: 0.003}, pngImage, "", models.ErrTooLarge},
+		{"decompression bomb", UploadLimits{MaxMegapixels: 100}, pngDeclaring(50000, 50000), "", models.ErrTooLarge},
+
+		{"type not allowed", UploadLimits{AllowedTypes: []string{"image/png"}}, jpegImage, "", models.ErrUnsupportedType},
+		{"text", defaults, []byte("just some text"), "", models.ErrUnsupportedType},
+		{"html", defaults, []byte("<!DOCTYPE html><html><body><img src=x></body></html>"), "", models.ErrUnsupportedType},
+		{"svg", defaults, []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`), "", models.ErrUnsupportedType},
+		{"pdf", defaults, []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), "", models.ErrUnsupportedType},
+		{"bmp", UploadLimits{AllowedTypes: []string{"image/png"}}, []byte("BM\x36\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00"), "", models.ErrUnsupportedType},
+		{"png magic followed by garbage", defaults, []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("garbage", 10)), "", models.ErrUnsupportedType},
+		{"tiff magic followed by garbage", defaults, []byte("II*\x00" + strings.Repeat("garbage", 10)), "", models.ErrUnsupportedType},
+		{"truncated png", defaults, pngImage[:20], "", models.ErrUnsupportedType},
+		{"empty", defaults, nil, "", models.ErrUnsupportedType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ImageService{limits: tt.limits}
+
+			upload, err := s.validateUpload(bytes.NewReader(tt.content))
+			var read []byte
+			if err == nil {
+				// The size is only known once the upload is read
+				read, err = io.ReadAll(upload.data)
+				if sizeErr := upload.sizeError(); sizeErr != nil {
+					err = sizeErr
+				}
+			}
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("validation = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validation = %v, want nil", err)
+			}
+			if upload.contentType != tt.contentType {
+				t.Errorf("content type %s, want %s", upload.contentType, tt.contentType)
+			}
+			if !bytes.Equal(read, tt.content) {
+				t.Errorf("read %d bytes back, want the %d bytes of the upload", len(read), len(tt.content))
+			}
+			if upload.data.n != int64(len(tt.content)) {
+				t.Errorf("checksummed %d bytes, want %d", upload.data.n, len(tt.content))
+			}
+		})
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"II*\x00\x08\x00\x00\x00", "image/tiff"},
+		{"MM\x00*\x00\x00\x00\x08", "image/tiff"},
+		{"\x89PNG\r\n\x1a\n", "image/png"},
+		{"\xff\xd8\xff\xe0", "image/jpeg"},
+		{"GIF89a", "image/gif"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"II+\x00", "application/octet-stream"},
+		{"plain text", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		if got := sniffContentType([]byte(tt.prefix)); got != tt.want {
+			t.Errorf("sniffContentType(%q) = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestCheckType(t *testing.T) {
+	limits := UploadLimits{AllowedTypes: DefaultAllowedTypes}
+
+	tests := []struct {
+		contentType string
+		allowed     bool
+	}{
+		{"image/jpeg", true},
+		{"image/png", true},
+		{"image/gif", true},
+		{"image/webp", true},
+		{"image/tiff", true},
+		{"image/svg+xml", false},
+		{"image/bmp", false},
+		{"image/png; charset=utf-8", false},
+		{"IMAGE/PNG", false},
+		{"text/html", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		err := limits.checkType(tt.contentType)
+		if tt.allowed && err != nil {
+			t.Errorf("checkType(%q) = %v, want nil", tt.contentType, err)
+		}
+		if !tt.allowed && !errors.Is(err, models.ErrUnsupportedType) {
+			t.Errorf("checkType(%q) = %v, want %v", tt.contentType, err, models.ErrUnsupportedType)
+		}
+	}
+
+	if err := (UploadLimits{}).checkType("text/html"); err != nil {
+		t.Errorf("checkType() without allowed types = %v, want nil", err)
+	}
+}
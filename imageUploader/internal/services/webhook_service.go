@@ -0,0 +1,421 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WebhookStore provides an interface for persisting the webhooks and their deliveries.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	ListWebhooks(ctx context.Context) ([]*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	CreateDeliveries(ctx context.Context, deliveries []*models.WebhookDelivery) error
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt) error
+	ListDeliveries(ctx context.Context, status string, limit int) ([]*models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error)
+	ResetDelivery(ctx context.Context, id uuid.UUID, now time.Time) error
+}
+
+// URLSigner provides an interface for pre-signing the download URLs of the stored objects.
+type URLSigner interface {
+	PresignURL(key string) (string, error)
+}
+
+// Store provides an interface for the storage backing the image records, the webhooks and the
+// resumable uploads.
+type Store interface {
+	MetadataStore
+	WebhookStore
+	UploadStore
+}
+
+// WebhookConfig configures the webhook deliveries.
+type WebhookConfig struct {
+	// Secret signs the deliveries to the callback URLs given with the uploads
+	Secret         string
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+const (
+	// webhookPollInterval is how often the due deliveries are looked up
+	webhookPollInterval = time.Second
+	// webhookConcurrency is the number of deliveries attempted concurrently
+	webhookConcurrency = 16
+	// deliveryListLimit caps the number of listed deliveries
+	deliveryListLimit = 100
+)
+
+// WebhookService signs and delivers the image events to the callback URLs.
+type WebhookService struct {
+	store  WebhookStore
+	urls   URLSigner
+	client *http.Client
+	cfg    WebhookConfig
+}
+
+// NewWebhookService creates a new WebhookService instance. The URLs of the variants in the
+// payloads are signed with urls when the deliveries are attempted.
+func NewWebhookService(store WebhookStore, urls URLSigner, cfg WebhookConfig) *WebhookService {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	return &WebhookService{
+		store: store,
+		urls:  urls,
+		client: &http.Client{
+			Transport: newPublicTransport(models.ErrInvalidCallbackURL, cfg.Timeout),
+			Timeout:   cfg.Timeout,
+			// The redirects are not followed, so that a receiver cannot send the deliveries to
+			// another address; they fail as non-2xx responses
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+	}
+}
+
+// ValidateCallbackURL checks that a per-upload callback URL can be delivered to.
+func (s *WebhookService) ValidateCallbackURL(callbackURL string) error {
+	if s.cfg.Secret == "" {
+		return fmt.Errorf("%w: callback URLs require WEBHOOK_SECRET to be set", models.ErrInvalidCallbackURL)
+	}
+	return validateURL(callbackURL)
+}
+
+// RegisterWebhook registers a callback URL notified about every image. The returned webhook
+// holds the generated secret signing its payloads.
+func (s *WebhookService) RegisterWebhook(callbackURL string) (*models.Webhook, error) {
+	if err := validateURL(callbackURL); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	webhook := &models.Webhook{
+		ID:        uuid.New(),
+		URL:       callbackURL,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.store.CreateWebhook(context.Background(), webhook); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+// ListWebhooks returns the registered callback URLs without their secrets.
+func (s *WebhookService) ListWebhooks() ([]*models.Webhook, error) {
+	webhooks, err := s.store.ListWebhooks(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a registered callback URL.
+func (s *WebhookService) DeleteWebhook(id uuid.UUID) error {
+	return s.store.DeleteWebhook(context.Background(), id)
+}
+
+// ListDeliveries returns the latest deliveries, optionally only those in the given state.
+func (s *WebhookService) ListDeliveries(status string) ([]*models.WebhookDelivery, error) {
+	return s.store.ListDeliveries(context.Background(), status, deliveryListLimit)
+}
+
+// GetDelivery returns a delivery with its attempts.
+func (s *WebhookService) GetDelivery(id uuid.UUID) (*models.WebhookDelivery, error) {
+	return s.store.GetDelivery(context.Background(), id)
+}
+
+// Redeliver schedules a new round of attempts of a delivery.
+func (s *WebhookService) Redeliver(id uuid.UUID) (*models.WebhookDelivery, error) {
+	if err := s.store.ResetDelivery(context.Background(), id, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return s.store.GetDelivery(context.Background(), id)
+}
+
+// Enqueue stores the deliveries of an image event to the registered webhooks and to the callback
+// URL of the image. They are attempted by Run. The payload is stored without the URLs of the
+// variants, which are signed for every attempt so that they are still valid when it is retried.
+func (s *WebhookService) Enqueue(ctx context.Context, eventType string, record *models.ImageRecord) error {
+	webhooks, err := s.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 && record.CallbackURL == "" {
+		return nil
+	}
+
+	image := *record
+	image.Variants = make([]models.Variant, len(record.Variants))
+	for i, variant := range record.Variants {
+		variant.URL = ""
+		image.Variants[i] = variant
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(&models.WebhookPayload{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: now,
+		Image:     &image,
+	})
+	if err != nil {
+		return err
+	}
+
+	newDelivery := func(webhookID *uuid.UUID, callbackURL string) *models.WebhookDelivery {
+		return &models.WebhookDelivery{
+			ID:            uuid.New(),
+			WebhookID:     webhookID,
+			ImageID:       record.ID,
+			URL:           callbackURL,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			Round:         1,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+	}
+
+	var deliveries []*models.WebhookDelivery
+	for _, webhook := range webhooks {
+		id := webhook.ID
+		deliveries = append(deliveries, newDelivery(&id, webhook.URL))
+	}
+	if record.CallbackURL != "" {
+		deliveries = append(deliveries, newDelivery(nil, record.CallbackURL))
+	}
+
+	return s.store.CreateDeliveries(ctx, deliveries)
+}
+
+// Run attempts the due deliveries until the context is cancelled, and waits for the attempts in
+// flight before returning. Every delivery is attempted on its own, at most webhookConcurrency at a
+// time, so a slow receiver only holds up its own deliveries. The deliveries are stored, so the
+// pending ones are resumed after a restart.
+func (s *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// inflight holds the deliveries being attempted. They stay due until their attempt is
+	// recorded, so they are only forgotten once reported on done, before the due deliveries are
+	// loaded again.
+	inflight := make(map[uuid.UUID]bool)
+	done := make(chan uuid.UUID, webhookConcurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for drained := false; !drained; {
+			select {
+			case id := <-done:
+				delete(inflight, id)
+			default:
+				drained = true
+			}
+		}
+		if len(inflight) >= webhookConcurrency {
+			continue
+		}
+
+		deliveries, err := s.store.DueDeliveries(ctx, time.Now().UTC(), webhookConcurrency+len(inflight))
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.Errorf("error occured while loading webhook deliveries: %v", err)
+			}
+			continue
+		}
+
+		for _, delivery := range deliveries {
+			if inflight[delivery.ID] || len(inflight) >= webhookConcurrency {
+				continue
+			}
+			inflight[delivery.ID] = true
+
+			wg.Add(1)
+			go func(delivery *models.WebhookDelivery) {
+				defer wg.Done()
+				s.attempt(ctx, delivery)
+				done <- delivery.ID
+			}(delivery)
+		}
+	}
+}
+
+// attempt posts the delivery once and schedules the next attempt when it fails.
+func (s *WebhookService) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	secret := delivery.Secret
+	if delivery.WebhookID == nil {
+		secret = s.cfg.Secret
+	}
+
+	started := time.Now().UTC()
+	statusCode, err := s.post(ctx, delivery, secret, started)
+	if ctx.Err() != nil {
+		// Shutting down, the delivery is attempted again after the restart
+		return
+	}
+
+	delivery.Attempts++
+	attempt := models.WebhookAttempt{
+		Round:       delivery.Round,
+		Attempt:     delivery.Attempts,
+		StatusCode:  statusCode,
+		DurationMs:  time.Since(started).Milliseconds(),
+		AttemptedAt: started,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	now := time.Now().UTC()
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = attempt.Error
+	delivery.UpdatedAt = now
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliverySucceeded
+	case delivery.Attempts >= s.cfg.MaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		logrus.Errorf("webhook delivery %s to %s failed after %d attempts: %v", delivery.ID, delivery.URL,
+			delivery.Attempts, err)
+	default:
+		delivery.NextAttemptAt = now.Add(s.backoff(delivery.Attempts))
+	}
+
+	if err = s.store.RecordAttempt(ctx, delivery, attempt); err != nil {
+		logrus.Errorf("error occured while recording webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// post sends the signed payload and returns the response status code. Responses other than
+// 2xx are errors.
+func (s *WebhookService) post(ctx context.Context, delivery *models.WebhookDelivery, secret string, now time.Time) (int, error) {
+	payload, err := s.signPayload(delivery.Payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "image-uploader-webhooks")
+	req.Header.Set("X-Webhook-Id", delivery.ID.String())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(secret, timestamp, payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload returns the stored payload with freshly pre-signed URLs of the variants.
+func (s *WebhookService) signPayload(stored []byte) ([]byte, error) {
+	var payload models.WebhookPayload
+	if err := json.Unmarshal(stored, &payload); err != nil {
+		return nil, fmt.Errorf("failed to read the stored payload: %v", err)
+	}
+	if payload.Image == nil || len(payload.Image.Variants) == 0 {
+		return stored, nil
+	}
+
+	for i := range payload.Image.Variants {
+		url, err := s.urls.PresignURL(payload.Image.Variants[i].Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign the %s variant URL: %v", payload.Image.Variants[i].Name, err)
+		}
+		payload.Image.Variants[i].URL = url
+	}
+
+	return json.Marshal(&payload)
+}
+
+// backoff returns the delay before the next attempt, doubling from the initial backoff.
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	backoff := s.cfg.InitialBackoff
+	for i := 1; i < attempts && backoff < s.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.cfg.MaxBackoff {
+		backoff = s.cfg.MaxBackoff
+	}
+	return backoff
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<payload>" with the secret, as sent in the
+// X-Webhook-Signature header.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateURL checks that the callback URL is an absolute HTTP(S) URL. The literal IP addresses
+// must be public; the host names are checked when the deliveries connect.
+func validateURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q", models.ErrInvalidCallbackURL, callbackURL)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", models.ErrInvalidCallbackURL, u.Hostname())
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/demius1992/Image-service/imageUploader/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp string
+		payload   []byte
+		want      string
+	}{
+		{"secret", "1700000000", []byte(`{"id":1}`), "3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11"},
+		{"", "0", nil, "b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+		{"whsec", "1700000000", []byte{0x00, 0xff}, "529bacaa0e9f71e7dde9ffd92bba23edb46aeb744cd5b2ae1996c2bc40d78357"},
+	}
+
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, tt.payload); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.payload, got, tt.want)
+		}
+	}
+
+	// The timestamp is part of the signature, so a replayed body needs the original timestamp
+	if Sign("secret", "1700000000", []byte("{}")) == Sign("secret", "1700000001", []byte("{}")) {
+		t.Error("the signature does not depend on the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  time.Duration
+		max      time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{"first retry", 10 * time.Second, time.Hour, 1, 10 * time.Second},
+		{"second retry", 10 * time.Second, time.Hour, 2, 20 * time.Second},
+		{"third retry", 10 * time.Second, time.Hour, 3, 40 * time.Second},
+		{"below the maximum", 10 * time.Second, time.Hour, 9, 2560 * time.Second},
+		{"capped", 10 * time.Second, time.Hour, 10, time.Hour},
+		{"capped after many attempts", 10 * time.Second, time.Hour, 1000, time.Hour},
+		{"initial over the maximum", 2 * time.Hour, time.Hour, 1, time.Hour},
+		{"initial at the maximum", time.Hour, time.Hour, 5, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WebhookService{cfg: WebhookConfig{InitialBackoff: tt.initial, MaxBackoff: tt.max}}
+			if got := s.backoff(tt.attempts); got != tt.want {
+				t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+// memoryWebhooks stores the webhook deliveries in memory.
+type memoryWebhooks struct {
+	mu         sync.Mutex
+	deliveries map[uuid.UUID]*models.WebhookDelivery
+	recorded   chan models.WebhookDelivery
+}
+
+func newMemoryWebhooks() *memoryWebhooks {
+	return &memoryWebhooks{
+		deliveries: make(map[uuid.UUID]*models.WebhookDelivery),
+		recorded:   make(chan models.WebhookDelivery, 100),
+	}
+}
+
+func (m *memoryWebhooks) CreateWebhook(context.Context, *models.Webhook) error { return nil }
+
+func (m *memoryWebhooks) ListWebhooks(context.Context) ([]*models.Webhook, error) { return nil, nil }
+
+func (m *memoryWebhooks) DeleteWebhook(context.Context, uuid.UUID) error { return nil }
+
+func (m *memoryWebhooks) CreateDeliveries(_ context.Context, deliveries []*models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range deliveries {
+		stored := *d
+		m.deliveries[d.ID] = &stored
+	}
+	return nil
+}
+
+func (m *memoryWebhooks) DueDeliveries(_ context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(now) && len(due) < limit {
+			delivery := *d
+			due = append(due, &delivery)
+		}
+	}
+	return due, nil
+}
+
+func (m *memoryWebhooks) RecordAttempt(_ context.Context, d *models.WebhookDelivery, _ models.WebhookAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *d
+	m.deliveries[d.ID] = &stored
+	m.recorded <- stored
+	return nil
+}
+
+func (m *memoryWebhooks) ListDeliveries(context.Context, string, int) ([]*models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *memoryWebhooks) GetDelivery(context.Context, uuid.UUID) (*models.WebhookDelivery, error) {
+	return nil, models.ErrNotFound
+}
+
+func (m *memoryWebhooks) ResetDelivery(context.Context, uuid.UUID, time.Time) error { return nil }
+
+// countingSigner signs the keys with the number of URLs signed so far.
+type countingSigner struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *countingSigner) PresignURL(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.n++
+	return fmt.Sprintf("https://storage.example/%s?signed=%d", key, c.n), nil
+}
+
+// newTestWebhookService creates a webhook service delivering to the test servers on loopback.
+func newTestWebhookService(store WebhookStore, cfg WebhookConfig) *WebhookService {
+	s := NewWebhookService(store, &countingSigner{}, cfg)
+	s.client.Transport = &http.Transport{}
+	return s
+}
+
+func newTestDelivery(url string, payload []byte) *models.WebhookDelivery {
+	now := time.Now().UTC()
+	return &models.WebhookDelivery{
+		ID:            uuid.New(),
+		ImageID:       uuid.New(),
+		URL:           url,
+		EventType:     models.WebhookImageReady,
+		Payload:       payload,
+		Status:        models.DeliveryPending,
+		Round:         1,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+func TestWebhookVariantURLsSignedPerAttempt(t *testing.T) {
+	type request struct {
+		body      []byte
+		timestamp string
+		signature string
+	}
+	requests := make(chan request, 10)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{body, r.Header.Get("X-Webhook-Timestamp"), r.Header.Get("X-Webhook-Signature")}
+		// The first attempt fails, so the delivery is retried
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	store := newMemoryWebhooks()
+	s := newTestWebhookService(store, WebhookConfig{Secret: "secret", MaxAttempts: 3, Timeout: 5 * time.Second})
+
+	// Enqueue drops the URLs signed when the record was loaded
+	record := &models.ImageRecord{
+		ID:          uuid.New(),
+		Status:      models.StatusReady,
+		CallbackURL: server.URL,
+		Variants:    []models.Variant{{Name: "small", Key: "img/small.jpg", URL: "https://expired.example/"}},
+	}
+	if err := s.Enqueue(context.Background(), models.WebhookImageReady, record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Variants[0].URL != "https://expired.example/" {
+		t.Error("Enqueue modified the record")
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		due, _ := store.DueDeliveries(context.Background(), time.Now().UTC().Add(time.Hour), 1)
+		if len(due) != 1 {
+			t.Fatalf("attempt %d: %d due deliveries, want 1", attempt, len(due))
+		}
+		if strings.Contains(string(due[0].Payload), "storage.example") || strings.Contains(string(due[0].Payload), "expired") {
+			t.Fatalf("the stored payload holds a variant URL: %s", due[0].Payload)
+		}
+		s.attempt(context.Background(), due[0])
+
+		req := <-requests
+		var payload models.WebhookPayload
+		if err := json.Unmarshal(req.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("https://storage.example/img/small.jpg?signed=%d", attempt)
+		if url := payload.Image.Variants[0].URL; url != want {
+			t.Errorf("attempt %d: variant URL %s, want %s", attempt, url, want)
+		}
+		if req.signature != "sha256="+Sign("secret", req.timestamp, req.body) {
+			t.Errorf("attempt %d: the signature does not match the body sent", attempt)
+		}
+	}
+
+	recorded := <-store.recorded
+	if recorded.Status != models.DeliveryPending || recorded.Attempts != 1 {
+		t.Errorf("first attempt recorded as %s after %d attempts, want a pending retry", recorded.Status, recorded.Attempts)
+	}
+	recorded = <-store.recorded
+	if recorded.Status != models.DeliverySucceeded || recorded.Attempts != 2 {
+		t.Errorf("second attempt recorded as %s after %d attempts, want succeeded", recorded.Status, recorded.Attempts)
+	}
+}
+
+func TestWebhookRunSlowReceiver(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	store := newMemoryWebhooks()
+	s := newTestWebhookService(store, WebhookConfig{Secret: "secret", MaxAttempts: 1, Timeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		release <- struct{}{}
+		<-stopped
+	}()
+
+	// The slow delivery is in flight before the other one is due
+	slow := newTestDelivery(server.URL+"/slow", []byte(`{}`))
+	store.CreateDeliveries(context.Background(), []*models.WebhookDelivery{slow})
+	deadline := time.After(5 * time.Second)
+	for {
+		mu.Lock()
+		started := hits["/slow"] == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("the slow delivery was not attempted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The later deliveries are attempted on the next polls, while the slow one is still in flight
+	for i := 0; i < 2; i++ {
+		fast := newTestDelivery(server.URL+"/fast", []byte(`{}`))
+		store.CreateDeliveries(context.Background(), []*models.WebhookDelivery{fast})
+		select {
+		case recorded := <-store.recorded:
+			if recorded.ID != fast.ID || recorded.Status != models.DeliverySucceeded {
+				t.Fatalf("recorded delivery %s as %s, want %s succeeded", recorded.ID, recorded.Status, fast.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the delivery was held up by the slow receiver")
+		}
+	}
+
+	// The delivery in flight is not attempted again while it is due
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/slow"] != 1 {
+		t.Errorf("the slow delivery was attempted %d times while in flight, want once", hits["/slow"])
+	}
+}
@@ -1,15 +1,66 @@
 package config
 
+import (
+	"github.com/demius1992/Image-service/storage"
+	"time"
+)
+
 // Config represents the application configuration.
 type Config struct {
-	Host             string   `mapstructure:"host"`
-	Port             string   `mapstructure:"port"`
-	AwsRegion        string   `mapstructure:"aws_region"`
-	AwsBucket        string   `mapstructure:"aws_bucket"`
-	KafkaBrokers     []string `mapstructure:"kafka_brokers"`
-	KafkaInputTopic  string   `mapstructure:"kafka_input_topic"`
-	KafkaOutputTopic string   `mapstructure:"kafka_output_topic"`
-	AccessKey        string   `mapstructure:"access_key"`
-	SecretKey        string   `mapstructure:"secret_key"`
-	Endpoint         string   `mapstructure:"endpoint"`
+	Host               string   `mapstructure:"host"`
+	Port               string   `mapstructure:"port"`
+	AwsRegion          string   `mapstructure:"aws_region"`
+	AwsBucket          string   `mapstructure:"aws_bucket"`
+	KafkaBrokers       []string `mapstructure:"kafka_brokers"`
+	KafkaInputTopic    string   `mapstructure:"kafka_input_topic"`
+	KafkaOutputTopic   string   `mapstructure:"kafka_output_topic"`
+	AccessKey          string   `mapstructure:"access_key"`
+	SecretKey          string   `mapstructure:"secret_key"`
+	Endpoint           string   `mapstructure:"endpoint"`
+	SanitizeOriginals  bool     `mapstructure:"sanitize_originals"`
+	DeduplicateUploads bool     `mapstructure:"deduplicate_uploads"`
+	VariantKeyTemplate string   `mapstructure:"variant_key_template"`
+	KafkaGroupID       string   `mapstructure:"kafka_group_id"`
+	MessageBus         string   `mapstructure:"message_bus"`
+	MetadataStore      string   `mapstructure:"metadata_store"`
+	SQLitePath         string   `mapstructure:"sqlite_path"`
+
+	StorageDriver        string `mapstructure:"storage_driver"`
+	StoragePath          string `mapstructure:"storage_path"`
+	StoragePublicURL     string `mapstructure:"storage_public_url"`
+	StorageSigningSecret string `mapstructure:"storage_signing_secret"`
+
+	WebhookSecret         string        `mapstructure:"webhook_secret"`
+	WebhookMaxAttempts    int           `mapstructure:"webhook_max_attempts"`
+	WebhookInitialBackoff time.Duration `mapstructure:"webhook_initial_backoff"`
+	WebhookMaxBackoff     time.Duration `mapstructure:"webhook_max_backoff"`
+	WebhookTimeout        time.Duration `mapstructure:"webhook_timeout"`
+
+	UploadAllowedTypes  []string `mapstructure:"upload_allowed_types"`
+	UploadMaxBytes      int64    `mapstructure:"upload_max_bytes"`
+	UploadMaxWidth      int      `mapstructure:"upload_max_width"`
+	UploadMaxHeight     int      `mapstructure:"upload_max_height"`
+	UploadMaxMegapixels float64  `mapstructure:"upload_max_megapixels"`
+
+	BatchConcurrency int   `mapstructure:"batch_concurrency"`
+	BatchMaxFiles    int   `mapstructure:"batch_max_files"`
+	BatchMaxBytes    int64 `mapstructure:"batch_max_bytes"`
+
+	RemoteFetchTimeout      time.Duration `mapstructure:"remote_fetch_timeout"`
+	RemoteFetchMaxRedirects int           `mapstructure:"remote_fetch_max_redirects"`
+}
+
+// Storage returns the configuration of the object store.
+func (c *Config) Storage() storage.Config {
+	return storage.Config{
+		Driver:        c.StorageDriver,
+		Bucket:        c.AwsBucket,
+		Region:        c.AwsRegion,
+		Endpoint:      c.Endpoint,
+		AccessKey:     c.AccessKey,
+		SecretKey:     c.SecretKey,
+		Path:          c.StoragePath,
+		PublicURL:     c.StoragePublicURL,
+		SigningSecret: c.StorageSigningSecret,
+	}
 }
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Load reads the configuration from the environment variables, with the defaults for the
+// limits that are not set.
+func Load() (*Config, error) {
+	cfg := &Config{
+		AwsRegion:          os.Getenv("S3_REGION"),
+		AwsBucket:          os.Getenv("S3_BUCKET"),
+		KafkaBrokers:       strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
+		KafkaOutputTopic:   os.Getenv("KAFKA_OUTPUT_TOPIC"),
+		KafkaInputTopic:    os.Getenv("KAFKA_INPUT_TOPIC"),
+		AccessKey:          os.Getenv("ACCESS_KEY"),
+		SecretKey:          os.Getenv("SECRET_KEY"),
+		Endpoint:           os.Getenv("ENDPOINT"),
+		SanitizeOriginals:  os.Getenv("SANITIZE_ORIGINALS") == "true",
+		DeduplicateUploads: os.Getenv("DEDUPLICATE_UPLOADS") == "true",
+		VariantKeyTemplate: os.Getenv("VARIANT_KEY_TEMPLATE"),
+		KafkaGroupID:       os.Getenv("KAFKA_GROUP_ID"),
+		MessageBus:         os.Getenv("MESSAGE_BUS"),
+		MetadataStore:      os.Getenv("METADATA_STORE"),
+		SQLitePath:         os.Getenv("SQLITE_PATH"),
+
+		StorageDriver:        os.Getenv("STORAGE_DRIVER"),
+		StoragePath:          os.Getenv("STORAGE_PATH"),
+		StoragePublicURL:     os.Getenv("STORAGE_PUBLIC_URL"),
+		StorageSigningSecret: os.Getenv("STORAGE_SIGNING_SECRET"),
+
+		WebhookSecret:         os.Getenv("WEBHOOK_SECRET"),
+		WebhookMaxAttempts:    8,
+		WebhookInitialBackoff: 10 * time.Second,
+		WebhookMaxBackoff:     time.Hour,
+		WebhookTimeout:        10 * time.Second,
+
+		UploadMaxBytes:      50 << 20,
+		UploadMaxWidth:      10000,
+		UploadMaxHeight:     10000,
+		UploadMaxMegapixels: 50,
+
+		BatchConcurrency: 4,
+		BatchMaxFiles:    500,
+		BatchMaxBytes:    1 << 30,
+
+		RemoteFetchTimeout:      30 * time.Second,
+		RemoteFetchMaxRedirects: 5,
+	}
+
+	// Configure the webhook deliveries
+	var err error
+	if value := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); value != "" {
+		if cfg.WebhookMaxAttempts, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS: %v", err)
+		}
+	}
+	if value := os.Getenv("WEBHOOK_INITIAL_BACKOFF"); value != "" {
+		if cfg.WebhookInitialBackoff, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid WEBHOOK_INITIAL_BACKOFF: %v", err)
+		}
+	}
+	if value := os.Getenv("WEBHOOK_MAX_BACKOFF"); value != "" {
+		if cfg.WebhookMaxBackoff, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid WEBHOOK_MAX_BACKOFF: %v", err)
+		}
+	}
+	if value := os.Getenv("WEBHOOK_TIMEOUT"); value != "" {
+		if cfg.WebhookTimeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid WEBHOOK_TIMEOUT: %v", err)
+		}
+	}
+
+	// Configure the upload limits
+	if value := os.Getenv("UPLOAD_ALLOWED_TYPES"); value != "" {
+		cfg.UploadAllowedTypes = strings.Split(value, ",")
+	}
+	if value := os.Getenv("UPLOAD_MAX_BYTES"); value != "" {
+		if cfg.UploadMaxBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid UPLOAD_MAX_BYTES: %v", err)
+		}
+	}
+	if value := os.Getenv("UPLOAD_MAX_WIDTH"); value != "" {
+		if cfg.UploadMaxWidth, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid UPLOAD_MAX_WIDTH: %v", err)
+		}
+	}
+	if value := os.Getenv("UPLOAD_MAX_HEIGHT"); value != "" {
+		if cfg.UploadMaxHeight, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid UPLOAD_MAX_HEIGHT: %v", err)
+		}
+	}
+	if value := os.Getenv("UPLOAD_MAX_MEGAPIXELS"); value != "" {
+		if cfg.UploadMaxMegapixels, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("invalid UPLOAD_MAX_MEGAPIXELS: %v", err)
+		}
+	}
+
+	// Configure the batch uploads
+	if value := os.Getenv("BATCH_CONCURRENCY"); value != "" {
+		if cfg.BatchConcurrency, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid BATCH_CONCURRENCY: %v", err)
+		}
+	}
+	if value := os.Getenv("BATCH_MAX_FILES"); value != "" {
+		if cfg.BatchMaxFiles, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid BATCH_MAX_FILES: %v", err)
+		}
+	}
+	if value := os.Getenv("BATCH_MAX_BYTES"); value != "" {
+		if cfg.BatchMaxBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid BATCH_MAX_BYTES: %v", err)
+		}
+	}
+
+	// Configure the downloads of remote images
+	if value := os.Getenv("REMOTE_FETCH_TIMEOUT"); value != "" {
+		if cfg.RemoteFetchTimeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid REMOTE_FETCH_TIMEOUT: %v", err)
+		}
+	}
+	if value := os.Getenv("REMOTE_FETCH_MAX_REDIRECTS"); value != "" {
+		if cfg.RemoteFetchMaxRedirects, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid REMOTE_FETCH_MAX_REDIRECTS: %v", err)
+		}
+	}
+
+	return cfg, nil
+}
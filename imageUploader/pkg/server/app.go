@@ -3,49 +3,158 @@ package server
 import (
 	"context"
 	"fmt"
+	"github.com/demius1992/Image-service/bus"
 	"github.com/demius1992/Image-service/imageUploader/internal/handlers"
 	"github.com/demius1992/Image-service/imageUploader/internal/repositories"
 	"github.com/demius1992/Image-service/imageUploader/internal/services"
 	"github.com/demius1992/Image-service/imageUploader/pkg/config"
+	"github.com/demius1992/Image-service/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"time"
 )
 
 type App struct {
-	httpServer    *http.Server
-	s3Repo        services.S3ImageRepository
-	kafkaService  services.KafkaService
-	imageServicer handlers.ImageServicer
+	httpServer     *http.Server
+	objectStore    storage.Storage
+	s3Repo         services.S3ImageRepository
+	messages       bus.Bus
+	ownsMessages   bool
+	kafkaService   services.KafkaService
+	store          services.Store
+	imageService   *services.ImageService
+	imageServicer  handlers.ImageServicer
+	webhookService *services.WebhookService
+	uploadService  *services.ResumableUploadService
+	uploadMaxBytes int64
+	batchMaxBytes  int64
 }
 
+// NewApp creates the application with the object store and the message bus selected in the
+// configuration.
 func NewApp(cfg *config.Config) (*App, error) {
 
-	// Initialize the S3 repositories
-	s3Repo, err := repositories.NewS3Repository(cfg.AwsRegion, cfg.AwsBucket)
+	// Initialize the storage repositories
+	objectStore, err := storage.New(cfg.Storage())
 	if err != nil {
 		return nil, fmt.Errorf("%s", err.Error())
 	}
 
+	// Initialize the message bus
+	messages, err := bus.New(bus.Config{Driver: cfg.MessageBus, Brokers: cfg.KafkaBrokers})
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := NewAppWithBackends(cfg, objectStore, messages)
+	if err != nil {
+		return nil, err
+	}
+	app.ownsMessages = true
+	return app, nil
+}
+
+// NewAppWithBackends creates the application on the given object store and message bus, which
+// can be shared with a resizer running in the same process. The message bus is left open on
+// shutdown for the caller to close.
+func NewAppWithBackends(cfg *config.Config, objectStore storage.Storage, messages bus.Bus) (*App, error) {
+	keyTemplate := cfg.VariantKeyTemplate
+	if keyTemplate == "" {
+		keyTemplate = "{id}/{variant}.{ext}"
+	}
+	s3Repo := repositories.NewStorageRepository(objectStore, keyTemplate)
+
+	// Initialize the metadata store
+	store, err := newMetadataStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize the services
-	kafkaService := services.NewKafkaService(cfg.KafkaBrokers, cfg.KafkaInputTopic, cfg.KafkaOutputTopic)
-	imageService := services.NewImageService(s3Repo, kafkaService)
+	groupID := cfg.KafkaGroupID
+	if groupID == "" {
+		groupID = "image-uploader"
+	}
+	kafkaService, err := services.NewKafkaService(messages, cfg.KafkaInputTopic, cfg.KafkaOutputTopic, groupID)
+	if err != nil {
+		return nil, err
+	}
+	webhookService := services.NewWebhookService(store, s3Repo, services.WebhookConfig{
+		Secret:         cfg.WebhookSecret,
+		MaxAttempts:    cfg.WebhookMaxAttempts,
+		InitialBackoff: cfg.WebhookInitialBackoff,
+		MaxBackoff:     cfg.WebhookMaxBackoff,
+		Timeout:        cfg.WebhookTimeout,
+	})
+	allowedTypes := cfg.UploadAllowedTypes
+	if len(allowedTypes) == 0 {
+		allowedTypes = services.DefaultAllowedTypes
+	}
+	imageService := services.NewImageService(s3Repo, kafkaService, store, webhookService, cfg.SanitizeOriginals,
+		cfg.DeduplicateUploads, services.UploadLimits{
+			AllowedTypes:  allowedTypes,
+			MaxBytes:      cfg.UploadMaxBytes,
+			MaxWidth:      cfg.UploadMaxWidth,
+			MaxHeight:     cfg.UploadMaxHeight,
+			MaxMegapixels: cfg.UploadMaxMegapixels,
+		}, services.BatchConfig{
+			Concurrency: cfg.BatchConcurrency,
+			MaxFiles:    cfg.BatchMaxFiles,
+		}, services.RemoteConfig{
+			Timeout:      cfg.RemoteFetchTimeout,
+			MaxRedirects: cfg.RemoteFetchMaxRedirects,
+		})
 
 	return &App{
-		s3Repo:        s3Repo,
-		kafkaService:  kafkaService,
-		imageServicer: imageService,
+		objectStore:    objectStore,
+		s3Repo:         s3Repo,
+		messages:       messages,
+		kafkaService:   kafkaService,
+		store:          store,
+		imageService:   imageService,
+		imageServicer:  imageService,
+		webhookService: webhookService,
+		uploadService:  services.NewResumableUploadService(s3Repo, store, imageService),
+		uploadMaxBytes: cfg.UploadMaxBytes,
+		batchMaxBytes:  cfg.BatchMaxBytes,
 	}, nil
 }
 
+// newMetadataStore creates the metadata store selected in the configuration.
+func newMetadataStore(cfg *config.Config) (services.Store, error) {
+	switch cfg.MetadataStore {
+	case "", "sqlite":
+		path := cfg.SQLitePath
+		if path == "" {
+			path = "images.db"
+		}
+		return repositories.NewSQLiteRepository(path)
+	default:
+		return nil, fmt.Errorf("unsupported metadata store %q", cfg.MetadataStore)
+	}
+}
+
+// Run serves the API until the process is interrupted.
 func (a *App) Run(port string) error {
-	// Initialize the handler
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return a.Serve(ctx, port)
+}
+
+// Serve serves the API until the context is done, then shuts the server down and closes the
+// services.
+func (a *App) Serve(ctx context.Context, port string) error {
+	// Initialize the handlers
 	imageHandler := handlers.NewImageHandler(a.imageServicer)
+	webhookHandler := handlers.NewWebhookHandler(a.webhookService)
+	tusHandler := handlers.NewTusHandler(a.uploadService)
 
 	// Initialize the Gin router
 	router := gin.Default()
@@ -55,16 +164,21 @@ func (a *App) Run(port string) error {
 		//gin.Logger(),
 	)
 
-	// Reads messages from kafka constantly
+	// Records the variants reported by the resizer until shutdown
+	events, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+
+	var background sync.WaitGroup
+	background.Add(2)
 	go func() {
-		for {
-			message, err := a.kafkaService.GetMessages()
-			if err != io.EOF {
-				logrus.Errorf("error occured while reading messages from kafka: %+v", err)
-				return
-			}
-			logrus.Printf("kafka message output: %s", message)
-		}
+		defer background.Done()
+		a.imageService.ProcessEvents(events)
+	}()
+
+	// Delivers the webhooks until shutdown
+	go func() {
+		defer background.Done()
+		a.webhookService.Run(events)
 	}()
 
 	// Register the HTTP endpoints
@@ -73,50 +187,123 @@ func (a *App) Run(port string) error {
 	})
 
 	router.GET("/kafka-check", func(c *gin.Context) {
-		err := a.kafkaService.SendMessage(context.Background(), uuid.New())
+		err := a.kafkaService.SendMessage(context.Background(), uuid.New(), false)
 		if err != nil {
 			logrus.Printf("error uccurred while sending a message to kafka: %v", err)
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		messages, err := a.kafkaService.GetMessages()
-		if err != nil {
-			if err != nil {
-				logrus.Printf("error uccurred while reading message from kafka: %v", err)
-				c.String(http.StatusInternalServerError, err.Error())
-				return
-			}
-			c.String(http.StatusOK, "OK", messages)
-		}
+		c.String(http.StatusOK, "OK")
 	})
 
 	router.POST("/images", imageHandler.UploadImage)
-	router.GET("/images/:name", imageHandler.GetImage)
+	router.GET("/images/:id", imageHandler.GetImage)
+	router.GET("/images/:id/variants", imageHandler.ListImageVariants)
+	router.GET("/images/:id/status", imageHandler.GetImageStatus)
+	router.GET("/images/:id/events", imageHandler.StreamImageEvents)
+	router.GET("/images/:id/:variant", imageHandler.GetImage)
+	router.POST("/images/batch", imageHandler.UploadBatch)
+	router.POST("/images/from-url", imageHandler.UploadImageFromURL)
+	router.POST("/images/upload-url", imageHandler.CreateUploadURL)
+	router.POST("/images/:id/complete", imageHandler.CompleteUpload)
+
+	uploads := router.Group("/uploads", tusHandler.Resumable)
+	uploads.OPTIONS("", tusHandler.Options)
+	uploads.POST("", tusHandler.CreateUpload)
+	uploads.HEAD("/:id", tusHandler.GetUpload)
+	uploads.PATCH("/:id", tusHandler.WriteChunk)
+	uploads.DELETE("/:id", tusHandler.DeleteUpload)
+
+	// Serve the links signed by the storage drivers that cannot pre-sign URLs themselves
+	if signer := storage.SignerOf(a.objectStore); signer != nil {
+		storageHandler := handlers.NewStorageHandler(a.objectStore, signer)
+		router.GET(storage.SignedPathPrefix+"*key", storageHandler.GetObject)
+		router.PUT(storage.SignedPathPrefix+"*key", storageHandler.PutObject)
+	}
+
+	router.POST("/webhooks", webhookHandler.RegisterWebhook)
+	router.GET("/webhooks", webhookHandler.ListWebhooks)
+	router.DELETE("/webhooks/:id", webhookHandler.DeleteWebhook)
+	router.GET("/webhooks/deliveries", webhookHandler.ListDeliveries)
+	router.GET("/webhooks/deliveries/:id", webhookHandler.GetDelivery)
+	router.POST("/webhooks/deliveries/:id/redeliver", webhookHandler.Redeliver)
 	router.POST("/images/variants", imageHandler.GetImageVariants)
 
 	// HTTP Server
 	a.httpServer = &http.Server{
 		Addr:           ":" + port,
-		Handler:        router,
+		Handler:        streamingHandler(router, a.uploadMaxBytes, a.batchMaxBytes),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
+	a.httpServer.RegisterOnShutdown(imageHandler.Shutdown)
 
 	go func() {
-		if err := a.httpServer.ListenAndServe(); err != nil {
+		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logrus.Fatalf("Failed to listen and serve: %+v", err)
 		}
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, os.Interrupt)
-
-	<-quit
+	<-ctx.Done()
 
-	ctx, shutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, shutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdown()
 
-	return a.httpServer.Shutdown(ctx)
+	err := a.httpServer.Shutdown(shutdownCtx)
+
+	stopEvents()
+	background.Wait()
+
+	if closeErr := a.kafkaService.Close(); closeErr != nil {
+		logrus.Errorf("error occured while closing kafka service: %v", closeErr)
+	}
+	if a.ownsMessages {
+		if closeErr := a.messages.Close(); closeErr != nil {
+			logrus.Errorf("error occured while closing the message bus: %v", closeErr)
+		}
+	}
+	if closeErr := a.store.Close(); closeErr != nil {
+		logrus.Errorf("error occured while closing metadata store: %v", closeErr)
+	}
+
+	return err
+}
+
+// uploadFormOverhead is the room left in the body of POST /images for the form fields and the
+// multipart framing around an image of the maximum size.
+const uploadFormOverhead = 1 << 20
+
+// streamingHandler lifts the write timeout of the server for the event streams, which stay open
+// until the image is processed, and for the remote uploads, whose download has its own timeout. It
+// lifts both timeouts for the uploads, whose size is bounded by uploadMaxBytes and batchMaxBytes
+// instead, and for the chunks of the resumable uploads, which are bounded by the length of the
+// upload, and for the signed storage links, which are bounded by the size of the object.
+func streamingHandler(next http.Handler, uploadMaxBytes, batchMaxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/events"), r.URL.Path == "/images/from-url":
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				logrus.Errorf("error occured while lifting the write timeout: %v", err)
+			}
+		case r.Method == http.MethodPost && r.URL.Path == "/images", r.URL.Path == "/images/batch",
+			r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/uploads/"),
+			strings.HasPrefix(r.URL.Path, storage.SignedPathPrefix):
+			controller := http.NewResponseController(w)
+			if err := controller.SetReadDeadline(time.Time{}); err != nil {
+				logrus.Errorf("error occured while lifting the read timeout: %v", err)
+			}
+			if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+				logrus.Errorf("error occured while lifting the write timeout: %v", err)
+			}
+			if uploadMaxBytes > 0 && r.URL.Path == "/images" {
+				r.Body = http.MaxBytesReader(w, r.Body, uploadMaxBytes+uploadFormOverhead)
+			}
+			if batchMaxBytes > 0 && r.URL.Path == "/images/batch" {
+				r.Body = http.MaxBytesReader(w, r.Body, batchMaxBytes)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
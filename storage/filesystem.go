@@ -0,0 +1,534 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// objectSuffix ends the name of the files holding the content of the objects
+	objectSuffix = ".object"
+	// metadataSuffix ends the name of the sidecar files describing the objects
+	metadataSuffix = ".meta.json"
+	// multipartDir holds the parts of the multipart uploads, one directory per upload
+	multipartDir = ".multipart"
+	// tmpDir holds the files being written, until they are renamed into place
+	tmpDir = ".tmp"
+)
+
+// FilesystemStorage keeps the objects in a directory tree mirroring their keys: the content of an
+// object is stored in a file named after the last segment of its key, next to a sidecar JSON file
+// describing it. The segments are escaped so that the directories never contain a dot while the
+// files always do, so an object and a directory never collide, and no key can escape the root.
+type FilesystemStorage struct {
+	root   string
+	signer *Signer
+}
+
+// NewFilesystemStorage creates a new FilesystemStorage instance storing the objects under root
+// and signing its links with the signer.
+func NewFilesystemStorage(root string, signer *Signer) (*FilesystemStorage, error) {
+	if root == "" {
+		return nil, errors.New("the storage path is not set")
+	}
+	root = filepath.Clean(root)
+	for _, dir := range []string{root, filepath.Join(root, multipartDir), filepath.Join(root, tmpDir)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create the storage directory: %v", err)
+		}
+	}
+
+	return &FilesystemStorage{
+		root:   root,
+		signer: signer,
+	}, nil
+}
+
+// fileMetadata is the content of the sidecar files.
+type fileMetadata struct {
+	Key          string            `json:"key"`
+	ContentType  string            `json:"content_type"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	LastModified time.Time         `json:"last_modified"`
+}
+
+// Signer returns the signer of the links.
+func (f *FilesystemStorage) Signer() *Signer {
+	return f.signer
+}
+
+// Put stores the object, replacing the existing one atomically.
+func (f *FilesystemStorage) Put(key string, body io.Reader, info ObjectInfo) error {
+	h := md5.New()
+	tmp, size, err := f.writeTemp(io.TeeReader(body, h))
+	if err != nil {
+		return err
+	}
+
+	return f.commit(key, tmp, fileMetadata{
+		ContentType: info.ContentType,
+		Size:        size,
+		ETag:        fmt.Sprintf("%x", h.Sum(nil)),
+		Metadata:    info.Metadata,
+	})
+}
+
+// writeTemp copies the content into a temporary file and returns its path and size.
+func (f *FilesystemStorage) writeTemp(content io.Reader) (string, int64, error) {
+	file, err := os.CreateTemp(filepath.Join(f.root, tmpDir), "object-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", 0, err
+	}
+	return file.Name(), size, nil
+}
+
+// commit moves the temporary content file of the object into place and writes its sidecar file.
+func (f *FilesystemStorage) commit(key, tmp string, meta fileMetadata) error {
+	path, err := f.objectPath(key)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path+objectSuffix); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	meta.Key = key
+	meta.ContentType = defaultContentType(meta.ContentType)
+	meta.Metadata = canonicalMetadata(meta.Metadata)
+	meta.LastModified = time.Now().UTC()
+	return f.writeMetadata(path, meta)
+}
+
+// writeMetadata replaces the sidecar file of the object stored at path atomically.
+func (f *FilesystemStorage) writeMetadata(path string, meta fileMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	tmp, _, err := f.writeTemp(strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path+metadataSuffix); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// readMetadata reads the sidecar file of the object stored at path.
+func (f *FilesystemStorage) readMetadata(key, path string) (*fileMetadata, error) {
+	data, err := os.ReadFile(path + metadataSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, err
+	}
+
+	var meta fileMetadata
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to read the metadata of %s: %v", key, err)
+	}
+	return &meta, nil
+}
+
+// Get returns the object.
+func (f *FilesystemStorage) Get(key string) (*Object, error) {
+	path, err := f.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path + objectSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, err
+	}
+	meta, err := f.readMetadata(key, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Object{ObjectInfo: meta.info(), Body: file}, nil
+}
+
+// Head describes the object.
+func (f *FilesystemStorage) Head(key string) (*ObjectInfo, error) {
+	path, err := f.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := f.readMetadata(key, path)
+	if err != nil {
+		return nil, err
+	}
+	info := meta.info()
+	return &info, nil
+}
+
+func (m *fileMetadata) info() ObjectInfo {
+	return ObjectInfo{
+		Key:          m.Key,
+		ContentType:  m.ContentType,
+		Size:         m.Size,
+		ETag:         m.ETag,
+		Metadata:     m.Metadata,
+		LastModified: m.LastModified,
+	}
+}
+
+// Delete removes the object, and the directories it leaves empty.
+func (f *FilesystemStorage) Delete(key string) error {
+	path, err := f.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{path + metadataSuffix, path + objectSuffix} {
+		if err = os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// Removing a directory fails as long as it holds other objects
+	for dir := filepath.Dir(path); dir != f.root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// List returns the keys starting with prefix. Only the directory holding the complete segments of
+// the prefix is walked.
+func (f *FilesystemStorage) List(prefix string, limit int) ([]string, error) {
+	dir := f.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		for _, segment := range strings.Split(prefix[:i], "/") {
+			dir = filepath.Join(dir, escapeSegment(segment, true))
+		}
+	}
+
+	var keys []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(entry.Name(), objectSuffix) {
+			return nil
+		}
+
+		key, err := f.pathKey(strings.TrimSuffix(path, objectSuffix))
+		if err == nil && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// UpdateInfo replaces the content type and metadata of the object.
+func (f *FilesystemStorage) UpdateInfo(key string, info ObjectInfo) error {
+	path, err := f.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	meta, err := f.readMetadata(key, path)
+	if err != nil {
+		return err
+	}
+	meta.ContentType = defaultContentType(info.ContentType)
+	meta.Metadata = canonicalMetadata(info.Metadata)
+	meta.LastModified = time.Now().UTC()
+	return f.writeMetadata(path, *meta)
+}
+
+// PresignGet returns a signed download link.
+func (f *FilesystemStorage) PresignGet(key string, duration time.Duration) (string, error) {
+	if _, err := f.objectPath(key); err != nil {
+		return "", err
+	}
+	return f.signer.SignGet(key, duration), nil
+}
+
+// PresignPut returns a signed upload link.
+func (f *FilesystemStorage) PresignPut(key, contentType string, size int64,
+	duration time.Duration) (string, http.Header, error) {
+	if _, err := f.objectPath(key); err != nil {
+		return "", nil, err
+	}
+	url, headers := f.signer.SignPut(key, contentType, size, duration)
+	return url, headers, nil
+}
+
+// CreateMultipart starts a multipart upload, whose directory holds the key and the parts.
+func (f *FilesystemStorage) CreateMultipart(key string) (string, error) {
+	if _, err := f.objectPath(key); err != nil {
+		return "", err
+	}
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(f.root, multipartDir, uploadID)
+	if err = os.Mkdir(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores a part of the multipart upload.
+func (f *FilesystemStorage) UploadPart(key, uploadID string, number int, data []byte) error {
+	if err := checkPartNumber(number); err != nil {
+		return err
+	}
+	dir, err := f.uploadDir(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	tmp, _, err := f.writeTemp(strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(dir, strconv.Itoa(number))); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// CompleteMultipart assembles the parts into the object.
+func (f *FilesystemStorage) CompleteMultipart(key, uploadID string) error {
+	dir, err := f.uploadDir(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var numbers []int
+	for _, entry := range entries {
+		if number, err := strconv.Atoi(entry.Name()); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+
+	sizes := make([]int64, len(numbers))
+	sums := make([][]byte, len(numbers))
+	parts := make([]io.Reader, len(numbers))
+	for i, number := range numbers {
+		path := filepath.Join(dir, strconv.Itoa(number))
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		sizes[i] = stat.Size()
+		parts[i] = &partReader{path: path, h: md5.New(), sum: &sums[i]}
+	}
+	if err = checkPartSizes(sizes); err != nil {
+		return err
+	}
+
+	tmp, size, err := f.writeTemp(io.MultiReader(parts...))
+	for _, part := range parts {
+		part.(*partReader).close()
+	}
+	if err != nil {
+		return err
+	}
+	if err = f.commit(key, tmp, fileMetadata{Size: size, ETag: multipartETag(sums)}); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// partReader copies a part into the object, opening its file only when it is reached so that the
+// parts are not all open at once, and computes its MD5.
+type partReader struct {
+	path string
+	file *os.File
+	h    hash.Hash
+	sum  *[]byte
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	if p.file == nil {
+		file, err := os.Open(p.path)
+		if err != nil {
+			return 0, err
+		}
+		p.file = file
+	}
+
+	n, err := p.file.Read(b)
+	p.h.Write(b[:n])
+	if err == io.EOF {
+		*p.sum = p.h.Sum(nil)
+		p.close()
+	}
+	return n, err
+}
+
+func (p *partReader) close() {
+	if p.file != nil {
+		p.file.Close()
+		p.file = nil
+	}
+}
+
+// AbortMultipart discards the multipart upload.
+func (f *FilesystemStorage) AbortMultipart(key, uploadID string) error {
+	dir, err := f.uploadDir(key, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// uploadDir returns the directory of the multipart upload of the key.
+func (f *FilesystemStorage) uploadDir(key, uploadID string) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return "", fmt.Errorf("%w: multipart upload %s", ErrNotFound, uploadID)
+	}
+
+	dir := filepath.Join(f.root, multipartDir, uploadID)
+	stored, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil || string(stored) != key {
+		return "", fmt.Errorf("%w: multipart upload %s", ErrNotFound, uploadID)
+	}
+	return dir, nil
+}
+
+// objectPath returns the path of the object files of the key, without their suffix.
+func (f *FilesystemStorage) objectPath(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%w: empty key", ErrNotFound)
+	}
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			return "", fmt.Errorf("%w: invalid key %q", ErrNotFound, key)
+		}
+		segments[i] = escapeSegment(segment, i < len(segments)-1)
+	}
+	return filepath.Join(f.root, filepath.Join(segments...)), nil
+}
+
+// pathKey returns the key of the object files at path, without their suffix.
+func (f *FilesystemStorage) pathKey(path string) (string, error) {
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil {
+		return "", err
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		if segments[i], err = unescapeSegment(segment); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// escapeSegment escapes the bytes of a key segment other than letters, digits, dashes,
+// underscores and dots as %XX. The dots are escaped as well in directory names and at the start
+// of file names, so that the names never start with a dot and only the files contain one.
+func escapeSegment(segment string, dir bool) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+			b.WriteByte(c)
+		case c == '.' && !dir && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeSegment reverses escapeSegment.
+func unescapeSegment(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", fmt.Errorf("invalid escaped name %q", name)
+		}
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escaped name %q", name)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
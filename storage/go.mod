@@ -0,0 +1,7 @@
+module github.com/demius1992/Image-service/storage
+
+go 1.20
+
+require github.com/aws/aws-sdk-go v1.44.204
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
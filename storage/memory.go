@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps the objects in memory. It suits the tests and the deployments running both
+// services in a single process; its content is lost on restart.
+type MemoryStorage struct {
+	signer *Signer
+
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryObject struct {
+	info ObjectInfo
+	data []byte
+}
+
+type memoryUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+// NewMemoryStorage creates a new MemoryStorage instance signing its links with the signer.
+func NewMemoryStorage(signer *Signer) *MemoryStorage {
+	return &MemoryStorage{
+		signer:  signer,
+		objects: make(map[string]*memoryObject),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+// Signer returns the signer of the links.
+func (m *MemoryStorage) Signer() *Signer {
+	return m.signer
+}
+
+// Put stores the object.
+func (m *MemoryStorage) Put(key string, body io.Reader, info ObjectInfo) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	m.store(key, data, info, fmt.Sprintf("%x", md5.Sum(data)))
+	return nil
+}
+
+func (m *MemoryStorage) store(key string, data []byte, info ObjectInfo, etag string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = &memoryObject{
+		info: ObjectInfo{
+			Key:          key,
+			ContentType:  defaultContentType(info.ContentType),
+			Size:         int64(len(data)),
+			ETag:         etag,
+			Metadata:     canonicalMetadata(info.Metadata),
+			LastModified: time.Now().UTC(),
+		},
+		data: data,
+	}
+}
+
+// Get returns the object.
+func (m *MemoryStorage) Get(key string) (*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	// The stored data is never modified, only replaced, so it can be read without a copy
+	return &Object{ObjectInfo: object.describe(), Body: io.NopCloser(bytes.NewReader(object.data))}, nil
+}
+
+// Head describes the object.
+func (m *MemoryStorage) Head(key string) (*ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	info := object.describe()
+	return &info, nil
+}
+
+// describe copies the description of the object, so that the caller cannot alter its metadata.
+func (o *memoryObject) describe() ObjectInfo {
+	info := o.info
+	info.Metadata = canonicalMetadata(o.info.Metadata)
+	return info
+}
+
+// Delete removes the object.
+func (m *MemoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+// List returns the keys starting with prefix.
+func (m *MemoryStorage) List(prefix string, limit int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// UpdateInfo replaces the content type and metadata of the object.
+func (m *MemoryStorage) UpdateInfo(key string, info ObjectInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	object, ok := m.objects[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	object.info.ContentType = defaultContentType(info.ContentType)
+	object.info.Metadata = canonicalMetadata(info.Metadata)
+	return nil
+}
+
+// PresignGet returns a signed download link.
+func (m *MemoryStorage) PresignGet(key string, duration time.Duration) (string, error) {
+	return m.signer.SignGet(key, duration), nil
+}
+
+// PresignPut returns a signed upload link.
+func (m *MemoryStorage) PresignPut(key, contentType string, size int64,
+	duration time.Duration) (string, http.Header, error) {
+	url, headers := m.signer.SignPut(key, contentType, size, duration)
+	return url, headers, nil
+}
+
+// CreateMultipart starts a multipart upload.
+func (m *MemoryStorage) CreateMultipart(key string) (string, error) {
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uploads[uploadID] = &memoryUpload{key: key, parts: make(map[int][]byte)}
+	return uploadID, nil
+}
+
+// UploadPart stores a part of the multipart upload.
+func (m *MemoryStorage) UploadPart(key, uploadID string, number int, data []byte) error {
+	if err := checkPartNumber(number); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, err := m.upload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	upload.parts[number] = append([]byte(nil), data...)
+	return nil
+}
+
+// CompleteMultipart assembles the parts into the object.
+func (m *MemoryStorage) CompleteMultipart(key, uploadID string) error {
+	m.mu.Lock()
+	upload, err := m.upload(key, uploadID)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	numbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	var data []byte
+	sizes := make([]int64, len(numbers))
+	sums := make([][]byte, len(numbers))
+	for i, number := range numbers {
+		part := upload.parts[number]
+		data = append(data, part...)
+		sizes[i] = int64(len(part))
+		sum := md5.Sum(part)
+		sums[i] = sum[:]
+	}
+	if err = checkPartSizes(sizes); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+
+	m.store(key, data, ObjectInfo{}, multipartETag(sums))
+	return nil
+}
+
+// AbortMultipart discards the multipart upload.
+func (m *MemoryStorage) AbortMultipart(key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.upload(key, uploadID); err != nil {
+		return err
+	}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+// upload returns the multipart upload of the key. The lock must be held.
+func (m *MemoryStorage) upload(key, uploadID string) (*memoryUpload, error) {
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return nil, fmt.Errorf("%w: multipart upload %s", ErrNotFound, uploadID)
+	}
+	return upload, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// minPartSize is the size S3 requires of every part of a multipart upload but the last one
+	minPartSize = 5 << 20
+	// maxParts is the number of parts a multipart upload can hold
+	maxParts = 10000
+)
+
+// checkPartNumber rejects the part numbers S3 does not accept.
+func checkPartNumber(number int) error {
+	if number < 1 || number > maxParts {
+		return fmt.Errorf("%w: part number %d out of range", ErrInvalidPart, number)
+	}
+	return nil
+}
+
+// checkPartSizes rejects the parts, in the order of their numbers, that S3 would not assemble.
+func checkPartSizes(sizes []int64) error {
+	if len(sizes) == 0 {
+		return fmt.Errorf("%w: no part uploaded", ErrInvalidPart)
+	}
+	for i, size := range sizes[:len(sizes)-1] {
+		if size < minPartSize {
+			return fmt.Errorf("%w: part %d holds less than %d bytes", ErrInvalidPart, i+1, minPartSize)
+		}
+	}
+	return nil
+}
+
+// multipartETag computes the ETag S3 gives to an object assembled from parts with the given MD5s.
+func multipartETag(sums [][]byte) string {
+	h := md5.New()
+	for _, sum := range sums {
+		h.Write(sum)
+	}
+	return fmt.Sprintf("%x-%d", h.Sum(nil), len(sums))
+}
+
+// newUploadID generates the ID of a multipart upload.
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// canonicalMetadata copies the metadata under canonical header keys, as S3 returns them.
+func canonicalMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	canonical := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		canonical[http.CanonicalHeaderKey(key)] = value
+	}
+	return canonical
+}
+
+// defaultContentType is the content type S3 gives to the objects stored without one.
+func defaultContentType(contentType string) string {
+	if contentType == "" {
+		return "binary/octet-stream"
+	}
+	return contentType
+}
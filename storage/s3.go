@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps the objects in an S3 bucket.
+type S3Storage struct {
+	bucket   string
+	svc      *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewS3Storage creates a new S3Storage instance. The endpoint, which may be empty, points the
+// client to an S3 compatible service such as LocalStack.
+func NewS3Storage(bucket, region, endpoint, accessKey, secretKey string) (*S3Storage, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(region),
+		Endpoint:         aws.String(endpoint),
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 session: %v", err)
+	}
+
+	// Create an S3 client object
+	svc := s3.New(sess)
+
+	// The uploader streams large objects in parts, buffering at most PartSize * Concurrency bytes
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = s3manager.MinUploadPartSize
+		u.Concurrency = 2
+	})
+
+	return &S3Storage{
+		bucket:   bucket,
+		svc:      svc,
+		uploader: uploader,
+	}, nil
+}
+
+// createBucket creates the S3 bucket if it does not exist.
+func (s *S3Storage) createBucket() error {
+	_, err := s.svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		// If the bucket already exists, ignore the error
+		if aerr, ok := err.(awserr.Error); !ok || (aerr.Code() != s3.ErrCodeBucketAlreadyExists &&
+			aerr.Code() != s3.ErrCodeBucketAlreadyOwnedByYou) {
+			return fmt.Errorf("failed to create the bucket: %v", err)
+		}
+	}
+	return nil
+}
+
+// notFound maps the missing objects and uploads to ErrNotFound.
+func notFound(err error, name string) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchUpload, "NotFound":
+			return fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+	}
+	return err
+}
+
+// Put streams the object to S3.
+func (s *S3Storage) Put(key string, body io.Reader, info ObjectInfo) error {
+	if err := s.createBucket(); err != nil {
+		return err
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		Body:     body,
+		Metadata: aws.StringMap(info.Metadata),
+	}
+	if info.ContentType != "" {
+		input.ContentType = aws.String(info.ContentType)
+	}
+	_, err := s.uploader.Upload(input)
+	return err
+}
+
+// Get returns the object.
+func (s *S3Storage) Get(key string) (*Object, error) {
+	resp, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+
+	return &Object{
+		ObjectInfo: s3Info(key, resp.ContentType, resp.ContentLength, resp.ETag, resp.Metadata, resp.LastModified),
+		Body:       resp.Body,
+	}, nil
+}
+
+// Head describes the object.
+func (s *S3Storage) Head(key string) (*ObjectInfo, error) {
+	resp, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, notFound(err, key)
+	}
+
+	info := s3Info(key, resp.ContentType, resp.ContentLength, resp.ETag, resp.Metadata, resp.LastModified)
+	return &info, nil
+}
+
+func s3Info(key string, contentType *string, size *int64, etag *string, metadata map[string]*string,
+	lastModified *time.Time) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		ContentType:  aws.StringValue(contentType),
+		Size:         aws.Int64Value(size),
+		ETag:         strings.Trim(aws.StringValue(etag), `"`),
+		Metadata:     canonicalMetadata(aws.StringValueMap(metadata)),
+		LastModified: aws.TimeValue(lastModified),
+	}
+}
+
+// Delete removes the object.
+func (s *S3Storage) Delete(key string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// List returns the keys starting with prefix.
+func (s *S3Storage) List(prefix string, limit int) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if limit > 0 && limit < 1000 {
+		input.MaxKeys = aws.Int64(int64(limit))
+	}
+
+	var keys []string
+	err := s.svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return limit <= 0 || len(keys) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// UpdateInfo replaces the content type and metadata of the object. The metadata of an object can
+// only be replaced by copying it onto itself.
+func (s *S3Storage) UpdateInfo(key string, info ObjectInfo) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(s.bucket + "/" + key),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		Metadata:          aws.StringMap(info.Metadata),
+	}
+	if info.ContentType != "" {
+		input.ContentType = aws.String(info.ContentType)
+	}
+	_, err := s.svc.CopyObject(input)
+	return notFound(err, key)
+}
+
+// PresignGet returns a pre-signed download URL.
+func (s *S3Storage) PresignGet(key string, duration time.Duration) (string, error) {
+	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return req.Presign(duration)
+}
+
+// PresignPut returns a pre-signed upload URL, whose signature covers the content type and size.
+func (s *S3Storage) PresignPut(key, contentType string, size int64,
+	duration time.Duration) (string, http.Header, error) {
+	req, _ := s.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	})
+
+	return req.PresignRequest(duration)
+}
+
+// CreateMultipart starts a multipart upload.
+func (s *S3Storage) CreateMultipart(key string) (string, error) {
+	if err := s.createBucket(); err != nil {
+		return "", err
+	}
+
+	resp, err := s.svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(resp.UploadId), nil
+}
+
+// UploadPart stores a part, which S3 checks against its MD5.
+func (s *S3Storage) UploadPart(key, uploadID string, number int, data []byte) error {
+	if err := checkPartNumber(number); err != nil {
+		return err
+	}
+
+	sum := md5.Sum(data)
+	_, err := s.svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(number)),
+		Body:       bytes.NewReader(data),
+		ContentMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	})
+	return notFound(err, uploadID)
+}
+
+// CompleteMultipart assembles the uploaded parts into the object.
+func (s *S3Storage) CompleteMultipart(key, uploadID string) error {
+	var parts []*s3.CompletedPart
+	err := s.svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		for _, part := range page.Parts {
+			parts = append(parts, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the parts: %w", notFound(err, uploadID))
+	}
+
+	_, err = s.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return notFound(err, uploadID)
+}
+
+// AbortMultipart discards the uploaded parts.
+func (s *S3Storage) AbortMultipart(key, uploadID string) error {
+	_, err := s.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return notFound(err, uploadID)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest splits a signed link into the key and the query verified by the uploader.
+func signedRequest(t *testing.T, link string) (string, url.Values) {
+	t.Helper()
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("invalid link %q: %v", link, err)
+	}
+	if !strings.HasPrefix(u.Path, SignedPathPrefix) {
+		t.Fatalf("link %q is not under %s", link, SignedPathPrefix)
+	}
+	return strings.TrimPrefix(u.Path, SignedPathPrefix), u.Query()
+}
+
+func TestSignerVerify(t *testing.T) {
+	signer := NewSigner("http://localhost:8080/", []byte("secret"))
+	const key = "4c4ac123/small image+1.jpg"
+
+	getLink := signer.SignGet(key, time.Minute)
+	putLink, _ := signer.SignPut(key, "image/png", 1024, time.Minute)
+
+	// tamper rewrites the query of a link
+	tamper := func(link string, edit func(q url.Values)) string {
+		u, _ := url.Parse(link)
+		q := u.Query()
+		edit(q)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	tests := []struct {
+		name        string
+		signer      *Signer
+		link        string
+		method      string
+		key         string
+		contentType string
+		size        int64
+		wantErr     bool
+	}{
+		{name: "get", link: getLink, method: http.MethodGet},
+		{name: "get ignores the body", link: getLink, method: http.MethodGet, contentType: "text/plain", size: 7},
+		{name: "put", link: putLink, method: http.MethodPut, contentType: "image/png", size: 1024},
+		{name: "expired get", link: signer.SignGet(key, -2*time.Second), method: http.MethodGet, wantErr: true},
+		{name: "expired put", link: func() string {
+			link, _ := signer.SignPut(key, "image/png", 1024, -2*time.Second)
+			return link
+		}(), method: http.MethodPut, contentType: "image/png", size: 1024, wantErr: true},
+		{name: "tampered signature", link: tamper(getLink, func(q url.Values) {
+			signature := []byte(q.Get("signature"))
+			if signature[0] == '0' {
+				signature[0] = '1'
+			} else {
+				signature[0] = '0'
+			}
+			q.Set("signature", string(signature))
+		}), method: http.MethodGet, wantErr: true},
+		{name: "extended expiry", link: tamper(getLink, func(q url.Values) {
+			q.Set("expires", "99999999999")
+		}), method: http.MethodGet, wantErr: true},
+		{name: "missing signature", link: tamper(getLink, func(q url.Values) { q.Del("signature") }),
+			method: http.MethodGet, wantErr: true},
+		{name: "signature not hex", link: tamper(getLink, func(q url.Values) { q.Set("signature", "zz") }),
+			method: http.MethodGet, wantErr: true},
+		{name: "missing expiry", link: tamper(getLink, func(q url.Values) { q.Del("expires") }),
+			method: http.MethodGet, wantErr: true},
+		{name: "other key", link: getLink, method: http.MethodGet, key: "4c4ac123/big.jpg", wantErr: true},
+		{name: "download link used to upload", link: getLink, method: http.MethodPut, contentType: "image/png",
+			size: 1024, wantErr: true},
+		{name: "upload link used to download", link: putLink, method: http.MethodGet, wantErr: true},
+		{name: "upload of another type", link: putLink, method: http.MethodPut, contentType: "text/html",
+			size: 1024, wantErr: true},
+		{name: "upload of another size", link: putLink, method: http.MethodPut, contentType: "image/png",
+			size: 1025, wantErr: true},
+		{name: "other secret", signer: NewSigner("http://localhost:8080", []byte("other")), link: getLink,
+			method: http.MethodGet, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			linkKey, query := signedRequest(t, tt.link)
+			if linkKey != key {
+				t.Fatalf("link key %q, want %q", linkKey, key)
+			}
+			if tt.key != "" {
+				linkKey = tt.key
+			}
+			verifier := signer
+			if tt.signer != nil {
+				verifier = tt.signer
+			}
+
+			err := verifier.Verify(tt.method, linkKey, query, tt.contentType, tt.size)
+			if tt.wantErr && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() = %v, want %v", err, ErrInvalidSignature)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Verify() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestSignerLinks(t *testing.T) {
+	signer := NewSigner("https://images.example.com/", []byte("secret"))
+
+	link := signer.SignGet("a b/c?d.jpg", time.Minute)
+	if want := "https://images.example.com/storage/a%20b/c%3Fd.jpg?"; !strings.HasPrefix(link, want) {
+		t.Errorf("SignGet() = %q, want a link starting with %q", link, want)
+	}
+
+	_, headers := signer.SignPut("key", "image/jpeg", 42, time.Minute)
+	if got := headers.Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type header = %q, want image/jpeg", got)
+	}
+	if got := headers.Get("Content-Length"); got != "42" {
+		t.Errorf("Content-Length header = %q, want 42", got)
+	}
+}
+
+func TestSignerOf(t *testing.T) {
+	signer := NewSigner("", []byte("secret"))
+	if SignerOf(NewMemoryStorage(signer)) != signer {
+		t.Error("SignerOf() did not return the signer of the memory storage")
+	}
+	if SignerOf(&S3Storage{}) != nil {
+		t.Error("SignerOf() returned a signer for S3, which signs its own URLs")
+	}
+}
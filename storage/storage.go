@@ -0,0 +1,125 @@
+// Package storage stores the images of the uploader and the resizer. The objects are kept in S3,
+// in a directory tree or in memory, depending on the configured driver.
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrNotFound is returned when an object or a multipart upload does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidPart is returned when a multipart upload cannot be assembled from its parts.
+var ErrInvalidPart = errors.New("invalid multipart upload part")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	ContentType string
+	Size        int64
+	// ETag is the hex MD5 of the content, or for assembled multipart uploads the MD5 of the part
+	// MD5s followed by a dash and the number of parts, like S3
+	ETag string
+	// Metadata holds user metadata, under canonical header keys
+	Metadata     map[string]string
+	LastModified time.Time
+}
+
+// Object is a stored object whose body streams its content. The body must be closed.
+type Object struct {
+	ObjectInfo
+	Body io.ReadCloser
+}
+
+// Storage provides an interface for storing objects under keys.
+type Storage interface {
+	// Put stores the content read from body under the key, with the content type and metadata
+	// of info, replacing the existing object.
+	Put(key string, body io.Reader, info ObjectInfo) error
+	Get(key string) (*Object, error)
+	Head(key string) (*ObjectInfo, error)
+	// Delete removes the object, and does nothing when it does not exist.
+	Delete(key string) error
+	// List returns at most limit keys starting with prefix, in lexical order.
+	List(prefix string, limit int) ([]string, error)
+	// UpdateInfo replaces the content type and metadata of an object.
+	UpdateInfo(key string, info ObjectInfo) error
+
+	// PresignGet returns a URL downloading the object until the duration elapses.
+	PresignGet(key string, duration time.Duration) (string, error)
+	// PresignPut returns a URL uploading an object of the given type and size with a PUT request
+	// until the duration elapses, and the headers the request must carry.
+	PresignPut(key, contentType string, size int64, duration time.Duration) (string, http.Header, error)
+
+	// CreateMultipart starts assembling an object in parts and returns the ID of the upload.
+	CreateMultipart(key string) (string, error)
+	// UploadPart stores a part, numbered from 1. Every part but the last one must hold at least
+	// 5 MB, like S3 requires.
+	UploadPart(key, uploadID string, number int, data []byte) error
+	// CompleteMultipart assembles the uploaded parts into the object, in the order of their numbers.
+	CompleteMultipart(key, uploadID string) error
+	// AbortMultipart discards the uploaded parts.
+	AbortMultipart(key, uploadID string) error
+}
+
+// Config selects and configures the storage driver.
+type Config struct {
+	// Driver is one of s3, fs and memory. It defaults to s3.
+	Driver string
+
+	// Bucket, Region, Endpoint, AccessKey and SecretKey configure the s3 driver
+	Bucket    string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+
+	// Path is the root directory of the fs driver
+	Path string
+
+	// PublicURL is the base URL of the links signed by the fs and memory drivers, which the
+	// uploader serves under /storage. The links are relative when it is empty.
+	PublicURL string
+	// SigningSecret signs the links of the fs and memory drivers. A random secret is used when it
+	// is empty, so the links do not survive restarts.
+	SigningSecret string
+}
+
+// New creates the storage driver selected in the configuration.
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "", "s3":
+		return NewS3Storage(cfg.Bucket, cfg.Region, cfg.Endpoint, cfg.AccessKey, cfg.SecretKey)
+	case "fs":
+		signer, err := newConfiguredSigner(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewFilesystemStorage(cfg.Path, signer)
+	case "memory":
+		signer, err := newConfiguredSigner(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewMemoryStorage(signer), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage driver %q", cfg.Driver)
+	}
+}
+
+// newConfiguredSigner creates the signer of the links, with a random secret if none is configured.
+func newConfiguredSigner(cfg Config) (*Signer, error) {
+	secret := []byte(cfg.SigningSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate the signing secret: %v", err)
+		}
+	}
+	return NewSigner(cfg.PublicURL, secret), nil
+}